@@ -1,25 +1,301 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"ncd/homework/tftp"
+	"net/netip"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
+// How long transfers in progress may take to finish once a shutdown signal arrives.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Println("Required argument port.")
+	listen := flag.String("listen", "", "address to listen on as host:port, instead of <port> on all interfaces")
+	network := flag.String("network", "udp", "network to listen on: udp (IPv4 and IPv6), udp4 or udp6")
+	portRange := flag.String("port-range", "", "local ports of transfers as min-max, instead of ports chosen by the OS")
+	root := flag.String("root", "", "directory to serve and store files in, instead of keeping them in memory")
+	maxMemory := flag.Int64("max-memory", 0, "bytes of memory the stored files and uploads may take, 0 for no limit")
+	maxFileSize := flag.Int("max-file-size", 0, "largest file kept in memory, in bytes, 0 for no limit")
+	maxFiles := flag.Int("max-files", 0, "number of files kept in memory, 0 for no limit")
+	evict := flag.String("evict", "none", "what to do when the memory limits are reached: none (refuse uploads) or lru (remove least recently used files)")
+	ttl := flag.Duration("ttl", 0, "how long files are kept in memory, 0 to keep them until overwritten")
+	snapshot := flag.String("snapshot", "", "file to load the files kept in memory from at startup and save them to on shutdown")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "how often to save the snapshot while running, 0 to only save on shutdown")
+	overwrite := flag.String("overwrite", "allow", "what to do with uploads of existing files: allow, deny, create-only or versions")
+	var overwriteRules []tftp.OverwriteRule
+	flag.Func("overwrite-rule", "overwrite policy for files matching a pattern, as pattern=policy (repeatable)", func(value string) error {
+		rule, err := tftp.ParseOverwriteRule(value)
+		if err != nil {
+			return err
+		}
+
+		overwriteRules = append(overwriteRules, rule)
+		return nil
+	})
+	access := flag.String("access", "read-write", "requests accepted: read-write, read-only, write-only or none")
+	var accessRules []tftp.AccessRule
+	flag.Func("access-rule", "access mode for files matching a pattern, as pattern=mode (repeatable)", func(value string) error {
+		rule, err := tftp.ParseAccessRule(value)
+		if err != nil {
+			return err
+		}
+
+		accessRules = append(accessRules, rule)
+		return nil
+	})
+	var clients, readClients, writeClients tftp.ClientACL
+	prefixFlag := func(name string, usage string, prefixes *[]netip.Prefix) {
+		flag.Func(name, usage, func(value string) error {
+			parsed, err := tftp.ParsePrefixes(value)
+			if err != nil {
+				return err
+			}
+
+			*prefixes = append(*prefixes, parsed...)
+			return nil
+		})
+	}
+	prefixFlag("allow", "networks allowed to send requests, as comma-separated CIDRs (repeatable)", &clients.Allow)
+	prefixFlag("deny", "networks refused, as comma-separated CIDRs (repeatable)", &clients.Deny)
+	prefixFlag("read-allow", "networks allowed to read files, as comma-separated CIDRs (repeatable)", &readClients.Allow)
+	prefixFlag("read-deny", "networks refused reading files, as comma-separated CIDRs (repeatable)", &readClients.Deny)
+	prefixFlag("write-allow", "networks allowed to write files, as comma-separated CIDRs (repeatable)", &writeClients.Allow)
+	prefixFlag("write-deny", "networks refused writing files, as comma-separated CIDRs (repeatable)", &writeClients.Deny)
+	dropRejected := flag.Bool("drop-rejected", false, "ignore requests of refused clients instead of answering with an access violation")
+	preloadDir := flag.String("preload-dir", "", "directory whose files are loaded into the storage before the server starts")
+	preloadTar := flag.String("preload-tar", "", "tar archive, optionally gzip compressed, whose files are loaded into the storage before the server starts")
+	var preloadFilter tftp.PreloadFilter
+	flag.Func("preload-include", "only preload the files matching a glob pattern (repeatable)", func(value string) error {
+		preloadFilter.Include = append(preloadFilter.Include, value)
+		return nil
+	})
+	flag.Func("preload-exclude", "do not preload the files matching a glob pattern (repeatable)", func(value string) error {
+		preloadFilter.Exclude = append(preloadFilter.Exclude, value)
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <port>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *network != "udp" && *network != "udp4" && *network != "udp6" {
+		fmt.Printf("Provided invalid network: %s \n", *network)
+		return
+	}
+
+	var transferPorts tftp.PortRange
+
+	if *portRange != "" {
+		var err error
+		transferPorts, err = tftp.ParsePortRange(*portRange)
+
+		if err != nil {
+			fmt.Printf("Provided invalid port range: %s \n", *portRange)
+			return
+		}
+	}
+
+	if *evict != "none" && *evict != "lru" {
+		fmt.Printf("Provided invalid eviction policy: %s \n", *evict)
 		return
 	}
 
-	port, err := strconv.Atoi(os.Args[1])
+	if *root != "" {
+		if name := memoryStorageFlag(); name != "" {
+			fmt.Printf("Flag -%s only applies to files kept in memory, it can't be used with -root \n", name)
+			return
+		}
+	}
+
+	overwritePolicy, err := tftp.ParseOverwritePolicy(*overwrite)
 
 	if err != nil {
-		fmt.Printf("Provided invalid port: %s \n", os.Args[1])
+		fmt.Printf("Provided invalid overwrite policy: %s \n", *overwrite)
+		return
+	}
+
+	accessMode, err := tftp.ParseAccessMode(*access)
+
+	if err != nil {
+		fmt.Printf("Provided invalid access mode: %s \n", *access)
+		return
+	}
+
+	if flag.NArg() == 0 && *listen == "" {
+		fmt.Println("Required argument port.")
+		return
+	}
+
+	port := 0
+
+	if flag.NArg() > 0 {
+		var err error
+		port, err = strconv.Atoi(flag.Arg(0))
+
+		if err != nil {
+			fmt.Printf("Provided invalid port: %s \n", flag.Arg(0))
+			return
+		}
+	}
+
+	var fileStorage tftp.FileStorage
+	var memoryStorage *tftp.MemoryFileStorage
+
+	if *root != "" {
+		storage, err := tftp.NewDirFileStorage(*root)
+
+		if err != nil {
+			fmt.Printf("Provided invalid root directory: %s \n", err)
+			return
+		}
+
+		if err := storage.RemoveStagedUploads(); err != nil {
+			fmt.Printf("Error when removing interrupted uploads: %s \n", err)
+			return
+		}
+
+		fileStorage = storage
+	} else {
+		storage := tftp.CreateEmptyMemoryStorage()
+		storage.MaxBytes = *maxMemory
+		storage.MaxFileSize = *maxFileSize
+		storage.MaxFiles = *maxFiles
+		storage.TTL = *ttl
+
+		if *evict == "lru" {
+			storage.Eviction = tftp.EvictLeastRecentlyUsed
+		}
+
+		if *snapshot != "" {
+			err := storage.LoadSnapshot(*snapshot)
+
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				fmt.Printf("Error when loading snapshot: %s \n", err)
+				return
+			}
+		}
+
+		memoryStorage = storage
+		fileStorage = tftp.NewBlockStorageAdapter(storage)
+	}
+
+	if err := preload(fileStorage, *preloadDir, *preloadTar, preloadFilter); err != nil {
+		fmt.Printf("Error when preloading files: %s \n", err)
 		return
 	}
 
-	tftp_server := tftp.NewServer(port)
-	tftp_server.Start()
+	tftp_server := tftp.NewServerWithStorage(port, fileStorage)
+	tftp_server.Addr = *listen
+	tftp_server.Network = *network
+	tftp_server.TransferPorts = transferPorts
+	tftp_server.Overwrite = overwritePolicy
+	tftp_server.OverwriteRules = overwriteRules
+	tftp_server.Access = accessMode
+	tftp_server.AccessRules = accessRules
+	tftp_server.Clients = clients
+	tftp_server.ReadClients = readClients
+	tftp_server.WriteClients = writeClients
+	tftp_server.DropRejectedClients = *dropRejected
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if memoryStorage != nil && *ttl > 0 {
+		go memoryStorage.RemoveExpiredEvery(signals, *ttl)
+	}
+
+	if memoryStorage != nil && *snapshot != "" && *snapshotInterval > 0 {
+		go memoryStorage.SaveSnapshotEvery(signals, *snapshot, *snapshotInterval)
+	}
+
+	drained := make(chan struct{})
+
+	go func() {
+		defer close(drained)
+		<-signals.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := tftp_server.Shutdown(ctx); err != nil {
+			fmt.Printf("Transfers did not finish in time: %s \n", err)
+		}
+
+		if memoryStorage != nil && *snapshot != "" {
+			if err := memoryStorage.SaveSnapshot(*snapshot); err != nil {
+				fmt.Printf("Error when saving snapshot: %s \n", err)
+			}
+		}
+	}()
+
+	if err := tftp_server.ListenAndServe(context.Background()); err != tftp.ErrServerClosed {
+		fmt.Printf("Server error: %s \n", err)
+		os.Exit(1)
+	}
+
+	// Serve returns as soon as the listener is closed, wait for the transfers to drain.
+	<-drained
+}
+
+// memoryStorageFlag returns the name of the first flag set on the command line
+// that configures the storage of files in memory, or "" if there is none.
+func memoryStorageFlag() string {
+	memoryFlags := map[string]bool{
+		"max-memory":        true,
+		"max-file-size":     true,
+		"max-files":         true,
+		"evict":             true,
+		"ttl":               true,
+		"snapshot":          true,
+		"snapshot-interval": true,
+	}
+
+	var name string
+	flag.Visit(func(f *flag.Flag) {
+		if name == "" && memoryFlags[f.Name] {
+			name = f.Name
+		}
+	})
+
+	return name
+}
+
+// preload loads the files of a directory and of a tar archive into storage,
+// printing a summary of each.
+func preload(storage tftp.FileStorage, dir string, tarPath string, filter tftp.PreloadFilter) error {
+	if dir != "" {
+		summary, err := tftp.PreloadDir(storage, dir, filter)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Preloaded from %s: %s \n", dir, summary)
+	}
+
+	if tarPath != "" {
+		archive, err := os.Open(tarPath)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		summary, err := tftp.PreloadTar(storage, archive, filter)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Preloaded from %s: %s \n", tarPath, summary)
+	}
+
+	return nil
 }
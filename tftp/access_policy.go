@@ -0,0 +1,120 @@
+package tftp
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// AccessMode decides which requests the server accepts for a file.
+type AccessMode int
+
+const (
+	// AccessReadWrite accepts both read and write requests.
+	AccessReadWrite AccessMode = iota
+	// AccessReadOnly accepts read requests only.
+	AccessReadOnly
+	// AccessWriteOnly accepts write requests only.
+	AccessWriteOnly
+	// AccessNone refuses every request.
+	AccessNone
+)
+
+var accessModeNames = []string{
+	AccessReadWrite: "read-write",
+	AccessReadOnly:  "read-only",
+	AccessWriteOnly: "write-only",
+	AccessNone:      "none",
+}
+
+// ParseAccessMode parses a mode named "read-write", "read-only", "write-only" or "none".
+func ParseAccessMode(value string) (AccessMode, error) {
+	for mode, name := range accessModeNames {
+		if value == name {
+			return AccessMode(mode), nil
+		}
+	}
+
+	return AccessReadWrite, fmt.Errorf("invalid access mode: %q", value)
+}
+
+func (m AccessMode) String() string {
+	if m < 0 || int(m) >= len(accessModeNames) {
+		return "AccessMode(" + strconv.Itoa(int(m)) + ")"
+	}
+
+	return accessModeNames[m]
+}
+
+// allows reports whether the mode accepts requests of op.
+func (m AccessMode) allows(op Op) bool {
+	switch op {
+	case OpRead:
+		return m == AccessReadWrite || m == AccessReadOnly
+	case OpWrite:
+		return m == AccessReadWrite || m == AccessWriteOnly
+	default:
+		return false
+	}
+}
+
+// AccessRule applies a mode to the files whose name matches Pattern, as
+// described for matchRequestPath.
+type AccessRule struct {
+	Pattern string
+	Mode    AccessMode
+}
+
+// ParseAccessRule parses a rule written as "pattern=mode".
+func ParseAccessRule(value string) (AccessRule, error) {
+	pattern, name, found := strings.Cut(value, "=")
+	if !found || pattern == "" {
+		return AccessRule{}, fmt.Errorf("invalid access rule: %q", value)
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return AccessRule{}, fmt.Errorf("invalid access rule: %q: %w", value, err)
+	}
+
+	mode, err := ParseAccessMode(name)
+	if err != nil {
+		return AccessRule{}, err
+	}
+
+	return AccessRule{Pattern: pattern, Mode: mode}, nil
+}
+
+// accessMode returns the mode of the first rule matching filename, or Access
+// when none does.
+func (s *TftpServer) accessMode(filename string) AccessMode {
+	for _, rule := range s.AccessRules {
+		if matchRequestPath(rule.Pattern, filename) {
+			return rule.Mode
+		}
+	}
+
+	return s.Access
+}
+
+// matchRequestPath reports whether a file name requested by a client matches
+// a rule pattern. Patterns use the syntax of path.Match, except that a pattern
+// ending with / matches every file under that directory. The name is matched
+// with / separating directories, after resolving "." and ".." elements, so
+// "backups/../golden.cfg" doesn't match "backups/".
+func matchRequestPath(pattern string, filename string) bool {
+	name := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(filename, `\`, "/")), "/")
+
+	if dirPattern := strings.TrimSuffix(pattern, "/"); dirPattern != pattern {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if matched, _ := path.Match(dirPattern, dir); matched {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
@@ -0,0 +1,75 @@
+package tftp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRequestPath(t *testing.T) {
+	assert.True(t, matchRequestPath("*.cfg", "switch.cfg"))
+	assert.False(t, matchRequestPath("*.cfg", "backups/switch.cfg"))
+	assert.True(t, matchRequestPath("backups/*.cfg", `backups\switch.cfg`))
+	assert.True(t, matchRequestPath("backups/*.cfg", "/backups/./switch.cfg"))
+
+	assert.True(t, matchRequestPath("backups/", "backups/switch.cfg"))
+	assert.True(t, matchRequestPath("backups/", "backups/site-1/switch.cfg"))
+	assert.True(t, matchRequestPath("*/", "site-1/switch.cfg"))
+	assert.False(t, matchRequestPath("backups/", "backups"))
+	assert.False(t, matchRequestPath("backups/", "golden.cfg"))
+	assert.False(t, matchRequestPath("backups/", "backups/../golden.cfg"))
+}
+
+func TestParseAccessRule(t *testing.T) {
+	rule, err := ParseAccessRule("backups/=write-only")
+	assert.NoError(t, err)
+	assert.Equal(t, AccessRule{Pattern: "backups/", Mode: AccessWriteOnly}, rule)
+
+	for _, value := range []string{"", "backups/", "=none", "backups/=append", "[=none"} {
+		_, err := ParseAccessRule(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestAccessRulesOverrideServerMode(t *testing.T) {
+	tftp_server := NewServer(0)
+	tftp_server.Access = AccessNone
+	tftp_server.AccessRules = []AccessRule{
+		{Pattern: "backups/", Mode: AccessWriteOnly},
+		{Pattern: "*.cfg", Mode: AccessReadOnly},
+	}
+
+	assert.True(t, tftp_server.accessMode("backups/switch.cfg").allows(OpWrite))
+	assert.False(t, tftp_server.accessMode("backups/switch.cfg").allows(OpRead))
+	assert.True(t, tftp_server.accessMode("golden.cfg").allows(OpRead))
+	assert.False(t, tftp_server.accessMode("golden.cfg").allows(OpWrite))
+	assert.False(t, tftp_server.accessMode("firmware.bin").allows(OpRead))
+	assert.False(t, tftp_server.accessMode("firmware.bin").allows(OpWrite))
+}
+
+func TestDeniedRequestsReportAccessViolation(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	tftp_server.Access = AccessReadOnly
+	tftp_server.AccessRules = []AccessRule{{Pattern: "backups/", Mode: AccessWriteOnly}}
+	uploadToMemoryStorage(memory_storage, "pxelinux.0", "bootloader")
+	uploadToMemoryStorage(memory_storage, "backups/switch.cfg", "hostname switch")
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "pxelinux.0", Mode: "octet"})
+	assertReceivedError(t, conn, ErrAccessViolation)
+
+	sendReadRequest(t, conn, server_addr, "backups/switch.cfg", "octet")
+	assertReceivedError(t, conn, ErrAccessViolation)
+
+	sendReadRequest(t, conn, server_addr, "pxelinux.0", "octet")
+	assertReceivedData(t, conn, []byte("bootloader"))
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "backups/router.cfg", Mode: "octet"})
+	assertReceivedAck(t, conn, 0)
+
+	assert.Equal(t, []byte("bootloader"), readMemoryStorage(t, memory_storage, "pxelinux.0", 0, 512))
+}
@@ -0,0 +1,206 @@
+package tftp
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// BlockFileStorage is a storage that receives uploads one block at a time and
+// serves byte ranges of stored files, such as MemoryFileStorage.
+// NewBlockStorageAdapter turns it into a FileStorage.
+type BlockFileStorage interface {
+	StartNewUpload(filename string) (FileMetadata, error)
+	AppendData(filename string, blockNum int, data []byte) error
+	CompleteUpload(filename string) error
+	AbortUpload(filename string) error
+	ReadFileBytes(filename string, start int, end int) ([]byte, error)
+	// GetFileMetadata describes a stored file. The Size of a complete file
+	// must be filled in, it is reported to clients asking for tsize.
+	GetFileMetadata(filename string) (FileMetadata, bool)
+}
+
+// NewBlockStorageAdapter serves the files of a BlockFileStorage through the
+// streaming FileStorage interface.
+func NewBlockStorageAdapter(storage BlockFileStorage) FileStorage {
+	return &blockStorageAdapter{storage: storage}
+}
+
+type blockStorageAdapter struct {
+	storage BlockFileStorage
+}
+
+func (a *blockStorageAdapter) Open(filename string) (io.ReadCloser, int64, error) {
+	notExist := &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+
+	reader, exists := newStorageReader(a.storage, filename)
+	if !exists {
+		return nil, 0, notExist
+	}
+
+	if reader.pinned {
+		return reader, int64(len(reader.contents)), nil
+	}
+
+	metadata, exists := a.storage.GetFileMetadata(filename)
+
+	if !exists || !metadata.IsComplete {
+		return nil, 0, notExist
+	}
+
+	return reader, int64(metadata.Size), nil
+}
+
+func (a *blockStorageAdapter) Create(filename string) (Upload, error) {
+	if staging, ok := a.storage.(stagingStorage); ok {
+		return staging.createUpload(filename)
+	}
+
+	if _, err := a.storage.StartNewUpload(filename); err != nil {
+		return nil, err
+	}
+
+	return &uploadWriter{storage: a.storage, filename: filename}, nil
+}
+
+// checkUploadSize checks the size of an upload declared by the client against
+// the limits of the storage, if it has any.
+func (a *blockStorageAdapter) checkUploadSize(filename string, size int64) error {
+	if limited, ok := a.storage.(sizeLimitedStorage); ok {
+		return limited.checkUploadSize(filename, size)
+	}
+
+	return nil
+}
+
+// snapshotStorage is implemented by storages that can hand out the contents
+// of a file as they are at a point in time, such as MemoryFileStorage.
+type snapshotStorage interface {
+	snapshot(filename string) ([]byte, bool)
+}
+
+// sizeLimitedStorage is implemented by storages with limits an upload can be
+// checked against before it starts, when its size is known, such as
+// MemoryFileStorage and the adapter serving it.
+type sizeLimitedStorage interface {
+	checkUploadSize(filename string, size int64) error
+}
+
+// stagingStorage is implemented by storages that can stage every upload on
+// its own, such as MemoryFileStorage. Uploads started through StartNewUpload
+// are identified by their file name only, so two concurrent uploads of the
+// same file would write to the same staging area.
+type stagingStorage interface {
+	createUpload(filename string) (Upload, error)
+}
+
+// storageReader reads a file from BlockFileStorage sequentially. ReadFileBytes
+// returns fewer bytes than asked for only at the end of the file, so a short
+// read is reported as io.EOF straight away.
+type storageReader struct {
+	storage  BlockFileStorage
+	filename string
+	offset   int
+
+	// contents holds the file when the storage supports snapshots.
+	contents []byte
+	pinned   bool
+}
+
+// newStorageReader opens a file for reading. When the storage supports
+// snapshots the contents are taken right away, so the file being overwritten
+// during the download doesn't mix two versions of it, and false is returned
+// if the file doesn't exist. Other storages are checked by the caller.
+func newStorageReader(storage BlockFileStorage, filename string) (*storageReader, bool) {
+	reader := &storageReader{storage: storage, filename: filename}
+
+	if snapshots, ok := storage.(snapshotStorage); ok {
+		contents, exists := snapshots.snapshot(filename)
+		if !exists {
+			return nil, false
+		}
+
+		reader.contents = contents
+		reader.pinned = true
+	}
+
+	return reader, true
+}
+
+func (r *storageReader) Read(p []byte) (int, error) {
+	var data []byte
+	if r.pinned {
+		data = sliceContents(r.contents, r.offset, r.offset+len(p))
+	} else {
+		var err error
+		data, err = r.storage.ReadFileBytes(r.filename, r.offset, r.offset+len(p))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, data)
+	r.offset += n
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (r *storageReader) Close() error {
+	r.contents = nil
+	return nil
+}
+
+// errUploadClosed is returned when an upload is used after it was committed or aborted.
+var errUploadClosed = errors.New("tftp: upload already closed")
+
+// uploadWriter appends everything written to it to the upload of a file.
+// Every write is recorded as the next block of the upload.
+type uploadWriter struct {
+	storage  BlockFileStorage
+	filename string
+	blockNum int
+	closed   bool
+}
+
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errUploadClosed
+	}
+
+	w.blockNum++
+	if err := w.storage.AppendData(w.filename, w.blockNum, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *uploadWriter) Commit() error {
+	if w.closed {
+		return errUploadClosed
+	}
+
+	w.closed = true
+	return w.storage.CompleteUpload(w.filename)
+}
+
+func (w *uploadWriter) Abort() error {
+	if w.closed {
+		return errUploadClosed
+	}
+
+	w.closed = true
+	return w.storage.AbortUpload(w.filename)
+}
+
+func (w *uploadWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	return w.Abort()
+}
@@ -0,0 +1,85 @@
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeThroughAdapter(t *testing.T, storage FileStorage, filename string, blocks ...string) Upload {
+	upload, err := storage.Create(filename)
+	assert.NoError(t, err)
+
+	for _, block := range blocks {
+		_, err := upload.Write([]byte(block))
+		assert.NoError(t, err)
+	}
+
+	return upload
+}
+
+func readThroughAdapter(t *testing.T, storage FileStorage, filename string) (string, int64) {
+	file, size, err := storage.Open(filename)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	contents, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	return string(contents), size
+}
+
+func TestBlockStorageAdapterCommit(t *testing.T) {
+	memory := CreateEmptyMemoryStorage()
+	storage := NewBlockStorageAdapter(memory)
+
+	upload := writeThroughAdapter(t, storage, "file", "Hello ", "World")
+
+	_, _, err := storage.Open("file")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	assert.NoError(t, upload.Commit())
+	assert.NoError(t, upload.Close())
+
+	contents, size := readThroughAdapter(t, storage, "file")
+	assert.Equal(t, "Hello World", contents)
+	assert.Equal(t, int64(11), size)
+
+	metadata, _ := memory.GetFileMetadata("file")
+	assert.Equal(t, 2, metadata.LastBlockNum)
+}
+
+func TestBlockStorageAdapterAbort(t *testing.T) {
+	storage := NewBlockStorageAdapter(CreateEmptyMemoryStorage())
+
+	upload := writeThroughAdapter(t, storage, "file", "old")
+	assert.NoError(t, upload.Commit())
+
+	upload = writeThroughAdapter(t, storage, "file", "new")
+	assert.NoError(t, upload.Abort())
+	assert.Error(t, upload.Commit())
+
+	upload = writeThroughAdapter(t, storage, "file", "newer")
+	assert.NoError(t, upload.Close())
+
+	contents, _ := readThroughAdapter(t, storage, "file")
+	assert.Equal(t, "old", contents)
+}
+
+// vanishingStorage reports files as stored while they are removed before their
+// contents can be taken, like a file evicted between the two.
+type vanishingStorage struct {
+	*MemoryFileStorage
+}
+
+func (s vanishingStorage) GetFileMetadata(filename string) (FileMetadata, bool) {
+	return FileMetadata{Filename: filename, IsComplete: true, Size: 5}, true
+}
+
+func TestBlockStorageAdapterOpenRemovedFile(t *testing.T) {
+	storage := NewBlockStorageAdapter(vanishingStorage{CreateEmptyMemoryStorage()})
+
+	_, _, err := storage.Open("file")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,31 @@
+package tftp
+
+import (
+	"math"
+)
+
+// Transfers count blocks with a 64-bit logical block number starting at 1,
+// while DATA and ACK packets only carry its lower 16 bits. Once block 65535
+// has been sent the wire number wraps around to 0, or to 1 with TftpServer.RolloverToOne.
+
+// wireBlockNum maps a logical block number to the number sent on the wire.
+func (s *TftpServer) wireBlockNum(blockNum uint64) uint16 {
+	if blockNum <= math.MaxUint16 || !s.RolloverToOne {
+		return uint16(blockNum)
+	}
+
+	// Block 0 is skipped, leaving 65535 numbers per cycle.
+	return uint16((blockNum-1)%math.MaxUint16 + 1)
+}
+
+// logicalBlockNum finds the block between first and last (inclusive) that is
+// sent as wireBlockNum on the wire.
+func (s *TftpServer) logicalBlockNum(wireBlockNum uint16, first uint64, last uint64) (uint64, bool) {
+	for blockNum := first; blockNum <= last; blockNum++ {
+		if s.wireBlockNum(blockNum) == wireBlockNum {
+			return blockNum, true
+		}
+	}
+
+	return 0, false
+}
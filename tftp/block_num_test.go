@@ -0,0 +1,45 @@
+package tftp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireBlockNumRollover(t *testing.T) {
+	tests := []struct {
+		rolloverToOne bool
+		blockNum      uint64
+		expected      uint16
+	}{
+		{false, 1, 1},
+		{false, 65535, 65535},
+		{false, 65536, 0},
+		{false, 65537, 1},
+		{false, 131072, 0},
+		{true, 65535, 65535},
+		{true, 65536, 1},
+		{true, 131070, 65535},
+		{true, 131071, 1},
+	}
+
+	for _, test := range tests {
+		server := TftpServer{RolloverToOne: test.rolloverToOne}
+		assert.Equal(t, test.expected, server.wireBlockNum(test.blockNum), "rollover to one %t, block %d", test.rolloverToOne, test.blockNum)
+	}
+}
+
+func TestLogicalBlockNum(t *testing.T) {
+	server := TftpServer{}
+
+	blockNum, ok := server.logicalBlockNum(0, 65530, 65540)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(65536), blockNum)
+
+	blockNum, ok = server.logicalBlockNum(3, 65530, 65540)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(65539), blockNum)
+
+	_, ok = server.logicalBlockNum(65529, 65530, 65540)
+	assert.False(t, ok)
+}
@@ -0,0 +1,121 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ClientACL decides which clients may send requests, by IP address.
+type ClientACL struct {
+	// Allow lists the networks whose clients are accepted. When empty every
+	// client not denied is accepted.
+	Allow []netip.Prefix
+	// Deny lists the networks whose clients are refused, even if allowed.
+	Deny []netip.Prefix
+}
+
+// ParsePrefixes parses a comma-separated list of networks in CIDR notation,
+// such as "10.0.0.0/8,fd00::/8". A single address stands for a network
+// holding only that address.
+func ParsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+
+		if !strings.Contains(element, "/") {
+			addr, err := netip.ParseAddr(element)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network: %q", element)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %q", element)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+func (a ClientACL) isEmpty() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0
+}
+
+// allows reports whether a client at ip is accepted.
+func (a ClientACL) allows(ip netip.Addr) bool {
+	for _, prefix := range a.Deny {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.Allow) == 0 {
+		return true
+	}
+
+	for _, prefix := range a.Allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientAllowed reports whether a request of op from peer passes both Clients
+// and the list of its operation. Peers whose IP address can't be told are
+// only accepted when no list applies to them.
+func (s *TftpServer) clientAllowed(peer net.Addr, op Op) bool {
+	acls := []ClientACL{s.Clients}
+
+	switch op {
+	case OpRead:
+		acls = append(acls, s.ReadClients)
+	case OpWrite:
+		acls = append(acls, s.WriteClients)
+	}
+
+	ip, known := peerIP(peer)
+
+	for _, acl := range acls {
+		if acl.isEmpty() {
+			continue
+		}
+
+		if !known || !acl.allows(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// peerIP returns the IP address of peer, with IPv4 addresses mapped to IPv6
+// by a dual-stack listener turned back into IPv4.
+func peerIP(peer net.Addr) (netip.Addr, bool) {
+	var ip netip.Addr
+
+	if udpAddr, ok := peer.(*net.UDPAddr); ok {
+		ip = udpAddr.AddrPort().Addr()
+	} else if addrPort, err := netip.ParseAddrPort(peer.String()); err == nil {
+		ip = addrPort.Addr()
+	}
+
+	if !ip.IsValid() {
+		return netip.Addr{}, false
+	}
+
+	return ip.Unmap().WithZone(""), true
+}
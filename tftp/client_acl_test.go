@@ -0,0 +1,85 @@
+package tftp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes("10.1.2.3/8, 192.168.0.7,fd00::/8")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.0.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}, prefixes)
+
+	prefixes, err = ParsePrefixes("")
+	assert.NoError(t, err)
+	assert.Empty(t, prefixes)
+
+	for _, value := range []string{"10.0.0.0/33", "switches", "10.0.0.0/8,fd00::/8/8"} {
+		_, err := ParsePrefixes(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestClientACLs(t *testing.T) {
+	tftp_server := NewServer(0)
+	tftp_server.Clients = ClientACL{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.66.0.0/16")},
+	}
+	tftp_server.WriteClients = ClientACL{Allow: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}}
+
+	peer := func(address string) net.Addr {
+		return net.UDPAddrFromAddrPort(netip.MustParseAddrPort(address))
+	}
+
+	assert.True(t, tftp_server.clientAllowed(peer("10.2.0.1:1000"), OpRead))
+	assert.False(t, tftp_server.clientAllowed(peer("10.2.0.1:1000"), OpWrite))
+	assert.True(t, tftp_server.clientAllowed(peer("10.1.0.1:1000"), OpWrite))
+	assert.True(t, tftp_server.clientAllowed(peer("[::ffff:10.1.0.1]:1000"), OpWrite))
+	assert.True(t, tftp_server.clientAllowed(peer("[fd00::1%eth0]:1000"), OpRead))
+	assert.False(t, tftp_server.clientAllowed(peer("10.66.0.1:1000"), OpRead))
+	assert.False(t, tftp_server.clientAllowed(peer("192.168.0.1:1000"), OpRead))
+
+	// Peers without an IP address are refused once a list applies to them.
+	assert.False(t, tftp_server.clientAllowed(memoryAddr("client"), OpRead))
+	assert.True(t, NewServer(0).clientAllowed(memoryAddr("client"), OpRead))
+}
+
+func TestRejectedClientsGetAccessViolation(t *testing.T) {
+	tftp_server, _, server_port, client_port := getTestResources(t)
+	tftp_server.WriteClients = ClientACL{Deny: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	// The storage mock has no expectations, it fails the test if it is asked for the file.
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "switch.cfg", Mode: "octet"})
+	assertReceivedError(t, conn, ErrAccessViolation)
+}
+
+func TestRejectedClientsCanBeDropped(t *testing.T) {
+	tftp_server, _, server_port, client_port := getTestResources(t)
+	tftp_server.Clients = ClientACL{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	tftp_server.DropRejectedClients = true
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "switch.cfg", "octet")
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	_, _, err := conn.ReadFromUDP(make([]byte, MaxPacketSize))
+	assert.Error(t, err, "Expected the request to be ignored.")
+}
@@ -0,0 +1,274 @@
+package tftp
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Mode of the files stored by DirFileStorage.
+const dirFileMode fs.FileMode = 0644
+
+// errOutsideRoot is returned for file names that would reach outside the root
+// directory of a DirFileStorage. It is reported to clients as an access violation.
+var errOutsideRoot = fmt.Errorf("path outside the root directory: %w", fs.ErrPermission)
+
+// errInvalidName is returned for file names that are empty or contain a NUL byte.
+var errInvalidName = fmt.Errorf("invalid file name: %w", fs.ErrPermission)
+
+// errStagedUpload is returned for file names of uploads in progress, which
+// must neither be read while half-written nor be written by clients.
+var errStagedUpload = fmt.Errorf("file name reserved for uploads in progress: %w", fs.ErrPermission)
+
+// stagedUploadInfix separates the name of a file from the random suffix of
+// the temporary files its uploads are written to.
+const stagedUploadInfix = ".upload-"
+
+// DirFileStorage serves and stores files under a root directory on disk.
+//
+// File names are relative to the root, with either / or \ separating
+// directories. Names that are absolute, contain a NUL byte or a ".." element,
+// or lead outside the root through a symbolic link are rejected. Uploads are
+// written to a temporary file next to their destination, which is renamed
+// over the destination once the upload is committed, so readers see either
+// the old or the new contents of a file but never a partial one. Requests for
+// the names of these temporary files are rejected.
+type DirFileStorage struct {
+	root string
+}
+
+// NewDirFileStorage returns a storage for the files under the directory root.
+func NewDirFileStorage(root string) (*DirFileStorage, error) {
+	absolute, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := filepath.EvalSymlinks(absolute)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: root, Err: syscall.ENOTDIR}
+	}
+
+	return &DirFileStorage{root: resolved}, nil
+}
+
+// Root returns the directory the files are stored in.
+func (s *DirFileStorage) Root() string {
+	return s.root
+}
+
+func (s *DirFileStorage) Open(filename string) (io.ReadCloser, int64, error) {
+	path, err := s.resolve("open", filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Symbolic links are allowed as long as they stay under the root.
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !s.contains(resolved) {
+		return nil, 0, &fs.PathError{Op: "open", Path: filename, Err: errOutsideRoot}
+	}
+
+	if isStagedUpload(filepath.Base(resolved)) {
+		return nil, 0, &fs.PathError{Op: "open", Path: filename, Err: errStagedUpload}
+	}
+
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	return file, info.Size(), nil
+}
+
+func (s *DirFileStorage) Create(filename string) (Upload, error) {
+	path, err := s.resolve("create", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// The destination itself may be a symbolic link, the rename replaces the
+	// link rather than writing through it, so only its directory is checked.
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.contains(dir) {
+		return nil, &fs.PathError{Op: "create", Path: filename, Err: errOutsideRoot}
+	}
+
+	target := filepath.Join(dir, filepath.Base(path))
+
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: filename, Err: fs.ErrPermission}
+	}
+
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(path)+stagedUploadInfix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirUpload{file: temp, target: target}, nil
+}
+
+// resolve checks a file name requested by a client and returns its path under
+// the root, without following symbolic links.
+func (s *DirFileStorage) resolve(op string, filename string) (string, error) {
+	if filename == "" || strings.ContainsRune(filename, 0) {
+		return "", &fs.PathError{Op: op, Path: filename, Err: errInvalidName}
+	}
+
+	name := strings.ReplaceAll(filename, `\`, "/")
+
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(filename) || filepath.VolumeName(filename) != "" {
+		return "", &fs.PathError{Op: op, Path: filename, Err: errOutsideRoot}
+	}
+
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return "", &fs.PathError{Op: op, Path: filename, Err: errOutsideRoot}
+		}
+	}
+
+	name = filepath.Clean(filepath.FromSlash(name))
+	if name == "." {
+		return "", &fs.PathError{Op: op, Path: filename, Err: errInvalidName}
+	}
+
+	if isStagedUpload(filepath.Base(name)) {
+		return "", &fs.PathError{Op: op, Path: filename, Err: errStagedUpload}
+	}
+
+	return filepath.Join(s.root, name), nil
+}
+
+// RemoveStagedUploads deletes the temporary files of uploads under the root,
+// such as the ones left behind when the server crashed. It must not be called
+// while uploads are in progress.
+func (s *DirFileStorage) RemoveStagedUploads() error {
+	return filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Type().IsRegular() && isStagedUpload(entry.Name()) {
+			return os.Remove(path)
+		}
+
+		return nil
+	})
+}
+
+// isStagedUpload reports whether a base name is one of the temporary files
+// uploads are written to, "." followed by the name of the destination,
+// stagedUploadInfix and a random number.
+func isStagedUpload(name string) bool {
+	i := strings.LastIndex(name, stagedUploadInfix)
+	if !strings.HasPrefix(name, ".") || i < 1 {
+		return false
+	}
+
+	suffix := name[i+len(stagedUploadInfix):]
+	return suffix != "" && strings.Trim(suffix, "0123456789") == ""
+}
+
+// contains reports whether a resolved path is the root or lies under it.
+func (s *DirFileStorage) contains(path string) bool {
+	relative, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return false
+	}
+
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// dirUpload is an upload to DirFileStorage, written to a temporary file.
+type dirUpload struct {
+	file   *os.File
+	target string
+	closed bool
+}
+
+func (u *dirUpload) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, errUploadClosed
+	}
+
+	return u.file.Write(p)
+}
+
+// Commit flushes the upload to disk and renames it over the destination.
+func (u *dirUpload) Commit() error {
+	if u.closed {
+		return errUploadClosed
+	}
+
+	u.closed = true
+
+	err := u.file.Chmod(dirFileMode)
+	if err == nil {
+		err = u.file.Sync()
+	}
+
+	if closeErr := u.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(u.file.Name(), u.target)
+	}
+
+	if err != nil {
+		os.Remove(u.file.Name())
+	}
+
+	return err
+}
+
+// Abort removes the temporary file.
+func (u *dirUpload) Abort() error {
+	if u.closed {
+		return errUploadClosed
+	}
+
+	u.closed = true
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+func (u *dirUpload) Close() error {
+	if u.closed {
+		return nil
+	}
+
+	return u.Abort()
+}
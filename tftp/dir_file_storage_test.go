@@ -0,0 +1,171 @@
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDirStorage(t *testing.T) *DirFileStorage {
+	storage, err := NewDirFileStorage(t.TempDir())
+	assert.NoError(t, err)
+	return storage
+}
+
+func TestDirStorageRoundTrip(t *testing.T) {
+	storage := newTestDirStorage(t)
+	assert.NoError(t, os.Mkdir(filepath.Join(storage.Root(), "images"), 0755))
+
+	upload, err := storage.Create("images/boot.img")
+	assert.NoError(t, err)
+	_, err = upload.Write([]byte("Hello World"))
+	assert.NoError(t, err)
+
+	_, _, err = storage.Open("images/boot.img")
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Expected the upload to be invisible until committed.")
+
+	assert.NoError(t, upload.Commit())
+	assert.NoError(t, upload.Close())
+
+	file, size, err := storage.Open(`images\boot.img`)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	contents, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", string(contents))
+	assert.Equal(t, int64(11), size)
+
+	entries, err := os.ReadDir(filepath.Join(storage.Root(), "images"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "Expected no temporary files to be left behind.")
+}
+
+func TestDirStorageAbortKeepsOldContents(t *testing.T) {
+	storage := newTestDirStorage(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(storage.Root(), "file"), []byte("old"), 0644))
+
+	upload, err := storage.Create("file")
+	assert.NoError(t, err)
+	_, err = upload.Write([]byte("new"))
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Close())
+
+	contents, err := os.ReadFile(filepath.Join(storage.Root(), "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(contents))
+
+	entries, err := os.ReadDir(storage.Root())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "Expected the temporary file to be removed.")
+}
+
+func TestDirStorageRejectsNamesOutsideRoot(t *testing.T) {
+	storage := newTestDirStorage(t)
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(storage.Root(), "escape")))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(storage.Root(), "secret-link")))
+
+	for _, name := range []string{
+		"../secret",
+		"images/../../secret",
+		`..\secret`,
+		"/etc/passwd",
+		`\etc\passwd`,
+		"file\x00name",
+		"",
+		".",
+		"escape/secret",
+		"secret-link",
+	} {
+		_, _, err := storage.Open(name)
+		assert.ErrorIs(t, err, fs.ErrPermission, "open %q", name)
+		assert.Equal(t, ErrAccessViolation, storageErrorCode(err), "open %q", name)
+	}
+
+	for _, name := range []string{"../new-file", "/tmp/new-file", "new\x00file", "escape/new-file"} {
+		_, err := storage.Create(name)
+		assert.ErrorIs(t, err, fs.ErrPermission, "create %q", name)
+	}
+
+	_, err := os.Stat(filepath.Join(outside, "new-file"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDirStorageFollowsSymlinksInsideRoot(t *testing.T) {
+	storage := newTestDirStorage(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(storage.Root(), "file"), []byte("contents"), 0644))
+	assert.NoError(t, os.Symlink("file", filepath.Join(storage.Root(), "link")))
+
+	file, size, err := storage.Open("link")
+	assert.NoError(t, err)
+	file.Close()
+	assert.Equal(t, int64(8), size)
+}
+
+func TestDirStorageMapsErrors(t *testing.T) {
+	storage := newTestDirStorage(t)
+	assert.NoError(t, os.Mkdir(filepath.Join(storage.Root(), "dir"), 0755))
+
+	_, _, err := storage.Open("missing")
+	assert.Equal(t, ErrFileNotFound, storageErrorCode(err))
+
+	_, _, err = storage.Open("dir")
+	assert.Equal(t, ErrAccessViolation, storageErrorCode(err))
+
+	_, err = storage.Create("dir")
+	assert.Equal(t, ErrAccessViolation, storageErrorCode(err))
+
+	_, err = storage.Create("missing-dir/file")
+	assert.Equal(t, ErrFileNotFound, storageErrorCode(err))
+}
+
+func TestDirStorageRejectsStagedUploads(t *testing.T) {
+	storage := newTestDirStorage(t)
+
+	upload, err := storage.Create("file")
+	assert.NoError(t, err)
+	defer upload.Close()
+	_, err = upload.Write([]byte("half"))
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(storage.Root())
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	staged := entries[0].Name()
+
+	_, _, err = storage.Open(staged)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	_, err = storage.Create(staged)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	assert.NoError(t, os.Symlink(staged, filepath.Join(storage.Root(), "link")))
+	_, _, err = storage.Open("link")
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	file, _, err := storage.Open(".file.upload-notes")
+	if err == nil {
+		file.Close()
+	}
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Expected names that merely look alike to be allowed.")
+}
+
+func TestDirStorageRemoveStagedUploads(t *testing.T) {
+	storage := newTestDirStorage(t)
+	assert.NoError(t, os.Mkdir(filepath.Join(storage.Root(), "images"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(storage.Root(), "images", "boot.img"), []byte("boot"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(storage.Root(), "images", ".boot.img.upload-123456"), []byte("bo"), 0644))
+
+	assert.NoError(t, storage.RemoveStagedUploads())
+
+	entries, err := os.ReadDir(filepath.Join(storage.Root(), "images"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "boot.img", entries[0].Name())
+}
@@ -1,6 +0,0 @@
-package tftp
-
-type DownloadMetadata struct {
-	Filename     string
-	LastBlockNum int
-}
@@ -4,4 +4,5 @@ type FileMetadata struct {
 	Filename     string
 	IsComplete   bool
 	LastBlockNum int
+	Size         int
 }
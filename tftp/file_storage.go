@@ -1,9 +1,68 @@
 package tftp
 
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"syscall"
+)
+
+// FileStorage stores the files served by the server. Errors returned by its
+// methods, and by the readers and uploads it hands out, are reported to the
+// client with the matching TFTP error code: fs.ErrNotExist, fs.ErrPermission
+// and fs.ErrExist are recognised, as is an ErrorCode wrapped in the error.
 type FileStorage interface {
-	StartNewUpload(filename string) FileMetadata
-	AppendData(filename string, blockNum int, data []byte)
-	CompleteUpload(filename string)
-	ReadFileBytes(filename string, start int, end int) []byte
-	GetFileMetadata(filename string) (FileMetadata, bool)
+	// Open opens a complete file for reading and returns its size in bytes.
+	Open(filename string) (io.ReadCloser, int64, error)
+	// Create starts an upload of a file. The stored file is only replaced
+	// once the upload is committed.
+	Create(filename string) (Upload, error)
+}
+
+// Upload is a file being written to a FileStorage.
+type Upload interface {
+	io.Writer
+	// Commit stores the written data as the contents of the file.
+	Commit() error
+	// Abort discards the written data, leaving the stored file as it was.
+	Abort() error
+	// Close releases the upload, aborting it unless it has been committed.
+	Close() error
+}
+
+// storageErrorCode picks the TFTP error sent to the client when the storage fails.
+func storageErrorCode(err error) ErrorCode {
+	var code ErrorCode
+
+	switch {
+	case errors.As(err, &code):
+		return code
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrFileNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrAccessViolation
+	case errors.Is(err, fs.ErrExist):
+		return ErrExists
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT), errors.Is(err, syscall.EFBIG):
+		return ErrDiskFull
+	default:
+		return ErrNotDefined
+	}
+}
+
+// storageErrorMessage describes a storage failure to the client without
+// revealing details of the storage, such as paths on the server.
+func storageErrorMessage(code ErrorCode, filename string) string {
+	switch code {
+	case ErrFileNotFound:
+		return "File with name '" + filename + "' does not exist."
+	case ErrAccessViolation:
+		return "Access to '" + filename + "' is denied."
+	case ErrExists:
+		return "File with name '" + filename + "' already exists."
+	case ErrDiskFull:
+		return "Not enough space to store '" + filename + "'."
+	default:
+		return "Unknown error occurred."
+	}
 }
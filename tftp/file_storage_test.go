@@ -0,0 +1,20 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageErrorCode(t *testing.T) {
+	assert.Equal(t, ErrFileNotFound, storageErrorCode(&fs.PathError{Op: "open", Path: "file", Err: fs.ErrNotExist}))
+	assert.Equal(t, ErrAccessViolation, storageErrorCode(fs.ErrPermission))
+	assert.Equal(t, ErrExists, storageErrorCode(fmt.Errorf("create: %w", fs.ErrExist)))
+	assert.Equal(t, ErrDiskFull, storageErrorCode(&fs.PathError{Op: "write", Path: "file", Err: syscall.ENOSPC}))
+	assert.Equal(t, ErrDiskFull, storageErrorCode(fmt.Errorf("quota exceeded: %w", ErrDiskFull)))
+	assert.Equal(t, ErrNotDefined, storageErrorCode(errors.New("broken")))
+}
@@ -0,0 +1,54 @@
+package tftp
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// FSFileStorage serves the files of an fs.FS, such as an embed.FS, os.DirFS,
+// zip.Reader or fstest.MapFS. It is read-only: uploads are refused with an
+// access violation.
+//
+// File names may use / or \ to separate directories. Names that aren't
+// valid fs.FS paths, such as absolute paths or names containing "..", are
+// refused with an access violation as well.
+type FSFileStorage struct {
+	fsys fs.FS
+}
+
+// NewFSFileStorage returns a read-only storage serving the files of fsys.
+func NewFSFileStorage(fsys fs.FS) *FSFileStorage {
+	return &FSFileStorage{fsys: fsys}
+}
+
+func (s *FSFileStorage) Open(filename string) (io.ReadCloser, int64, error) {
+	name := strings.ReplaceAll(filename, `\`, "/")
+
+	if !fs.ValidPath(name) || name == "." {
+		return nil, 0, &fs.PathError{Op: "open", Path: filename, Err: errInvalidName}
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrPermission}
+	}
+
+	return file, info.Size(), nil
+}
+
+// Create always fails, the files of an fs.FS can't be written.
+func (s *FSFileStorage) Create(filename string) (Upload, error) {
+	return nil, &fs.PathError{Op: "create", Path: filename, Err: fs.ErrPermission}
+}
@@ -0,0 +1,44 @@
+package tftp
+
+import (
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSStorageServesFiles(t *testing.T) {
+	storage := NewFSFileStorage(fstest.MapFS{
+		"boot/pxelinux.0": {Data: []byte("Hello World")},
+	})
+
+	file, size, err := storage.Open(`boot\pxelinux.0`)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	contents, err := io.ReadAll(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", string(contents))
+	assert.Equal(t, int64(11), size)
+}
+
+func TestFSStorageRefusesUploadsAndInvalidNames(t *testing.T) {
+	storage := NewFSFileStorage(fstest.MapFS{
+		"boot/pxelinux.0": {Data: []byte("Hello World")},
+	})
+
+	_, err := storage.Create("boot/pxelinux.0")
+	assert.Equal(t, ErrAccessViolation, storageErrorCode(err))
+
+	for _, name := range []string{"/boot/pxelinux.0", "../boot/pxelinux.0", "boot/../boot/pxelinux.0", "", "."} {
+		_, _, err := storage.Open(name)
+		assert.Equal(t, ErrAccessViolation, storageErrorCode(err), "open %q", name)
+	}
+
+	_, _, err = storage.Open("boot")
+	assert.Equal(t, ErrAccessViolation, storageErrorCode(err))
+
+	_, _, err = storage.Open("missing")
+	assert.Equal(t, ErrFileNotFound, storageErrorCode(err))
+}
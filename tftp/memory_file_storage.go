@@ -2,59 +2,318 @@ package tftp
 
 import (
 	"fmt"
-	"math"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// MemoryFileStorage keeps files in memory. It is safe for concurrent use, and
+// is served through NewBlockStorageAdapter.
+//
+// Uploads are built in a buffer of their own and only replace the stored file
+// once complete, so readers never see half-written data. Stored contents are
+// never modified in place: a reader holding a file keeps seeing the contents
+// it started with, even if the file is overwritten meanwhile.
+//
+// The limits must be set before the storage is used. Uploads that would
+// exceed them fail with ErrDiskFull, unless Eviction makes room for them.
 type MemoryFileStorage struct {
-	files        map[string]*FileMetadata
-	fileContents map[string][]byte
+	// MaxBytes caps the bytes held by the storage, counting both the stored
+	// files and the uploads in progress. Zero means no limit.
+	MaxBytes int64
+	// MaxFileSize caps the size of a single file, in bytes. Zero means no limit.
+	MaxFileSize int
+	// MaxFiles caps the number of stored files. Zero means no limit.
+	MaxFiles int
+	// Eviction decides whether stored files are removed to make room for
+	// uploads that would exceed MaxBytes or MaxFiles.
+	Eviction EvictionPolicy
+	// TTL removes files stored for longer than this. Zero keeps files until
+	// they are overwritten or evicted.
+	TTL time.Duration
+
+	mu      sync.RWMutex
+	files   map[string]*memoryFile
+	uploads map[string]*memoryUpload
+	// used is the number of bytes held by stored files and uploads.
+	used int64
+	// uploading is the part of used held by uploads in progress.
+	uploading int64
+}
+
+// memoryFile is a stored file.
+type memoryFile struct {
+	metadata FileMetadata
+	contents []byte
+	stored   time.Time
+	// lastUsed is when the file was last stored or read, in Unix nanoseconds.
+	lastUsed atomic.Int64
+}
+
+// memoryUpload is a file being uploaded, not visible to readers yet.
+type memoryUpload struct {
+	metadata FileMetadata
+	contents []byte
 }
 
 func CreateEmptyMemoryStorage() *MemoryFileStorage {
 	return &MemoryFileStorage{
-		files:        map[string]*FileMetadata{},
-		fileContents: map[string][]byte{},
+		files:   map[string]*memoryFile{},
+		uploads: map[string]*memoryUpload{},
 	}
 }
 
-func (s *MemoryFileStorage) StartNewUpload(filename string) FileMetadata {
+func (s *MemoryFileStorage) StartNewUpload(filename string) (FileMetadata, error) {
 	newFile := FileMetadata{
 		Filename:     filename,
 		IsComplete:   false,
 		LastBlockNum: 0,
 	}
 
-	s.files[filename] = &newFile
-	s.fileContents[filename] = []byte{}
-	return newFile
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkFileCount(filename); err != nil {
+		return FileMetadata{}, err
+	}
+
+	if previous, exists := s.uploads[filename]; exists {
+		s.releaseUpload(len(previous.contents))
+	}
+
+	s.uploads[filename] = &memoryUpload{metadata: newFile, contents: []byte{}}
+	return newFile, nil
 }
 
-func (s *MemoryFileStorage) AppendData(filename string, blockNum int, data []byte) {
-	s.fileContents[filename] = append(s.fileContents[filename], data...)
-	s.files[filename].LastBlockNum = blockNum
+func (s *MemoryFileStorage) AppendData(filename string, blockNum int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	upload, exists := s.uploads[filename]
+	if !exists {
+		return uploadNotStarted("write", filename)
+	}
+
+	if err := s.reserve(filename, len(upload.contents), len(data)); err != nil {
+		return err
+	}
+
+	upload.contents = append(upload.contents, data...)
+	upload.metadata.LastBlockNum = blockNum
+	upload.metadata.Size = len(upload.contents)
+	return nil
+}
+
+// CompleteUpload replaces the stored file with the upload, readers opening
+// the file from now on get the new contents.
+func (s *MemoryFileStorage) CompleteUpload(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, exists := s.uploads[filename]
+	if !exists {
+		return uploadNotStarted("commit", filename)
+	}
+
+	delete(s.uploads, filename)
+	return s.publish(upload)
 }
 
-func (s *MemoryFileStorage) CompleteUpload(filename string) {
-	s.files[filename].IsComplete = true
+// publish makes an upload the stored contents of its file, or discards it if
+// the file doesn't fit under MaxFiles. It must be called with s.mu held.
+func (s *MemoryFileStorage) publish(upload *memoryUpload) error {
+	filename := upload.metadata.Filename
+
+	if err := s.checkFileCount(filename); err != nil {
+		s.releaseUpload(len(upload.contents))
+		return err
+	}
+
+	if previous, exists := s.files[filename]; exists {
+		s.used -= int64(len(previous.contents))
+	}
+
+	s.uploading -= int64(len(upload.contents))
+
+	now := time.Now()
+	file := &memoryFile{metadata: upload.metadata, contents: upload.contents, stored: now}
+	file.metadata.IsComplete = true
+	file.lastUsed.Store(now.UnixNano())
+	s.files[filename] = file
+
 	fmt.Printf("Completing upload of file: %s \n", filename)
+	return nil
+}
+
+// createUpload starts an upload staged in a buffer of its own, rather than
+// under its file name like StartNewUpload, so concurrent uploads of the same
+// file can't mix their blocks. NewBlockStorageAdapter uses it in place of the
+// block methods.
+func (s *MemoryFileStorage) createUpload(filename string) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkFileCount(filename); err != nil {
+		return nil, err
+	}
+
+	return &stagedMemoryUpload{
+		storage: s,
+		upload:  memoryUpload{metadata: FileMetadata{Filename: filename}, contents: []byte{}},
+	}, nil
 }
 
-func (s *MemoryFileStorage) ReadFileBytes(filename string, start int, end int) []byte {
+// AbortUpload discards an upload, leaving the stored file as it was.
+func (s *MemoryFileStorage) AbortUpload(filename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	fullContent := s.fileContents[filename]
+	upload, exists := s.uploads[filename]
+	if !exists {
+		return uploadNotStarted("abort", filename)
+	}
+
+	delete(s.uploads, filename)
+	s.releaseUpload(len(upload.contents))
+
+	fmt.Printf("Aborting upload of file: %s \n", filename)
+	return nil
+}
+
+func (s *MemoryFileStorage) ReadFileBytes(filename string, start int, end int) ([]byte, error) {
+	contents, exists := s.snapshot(filename)
+	if !exists {
+		return nil, &fs.PathError{Op: "read", Path: filename, Err: fs.ErrNotExist}
+	}
 
-	start = int(math.Min(float64(start), float64(len(fullContent))))
-	end = int(math.Min(float64(end), float64(len(fullContent))))
-	return s.fileContents[filename][start:end]
+	// The stored contents are shared with readers, callers get a copy of their own.
+	return append([]byte(nil), sliceContents(contents, start, end)...), nil
 }
 
+// GetFileMetadata returns the metadata of a stored file. Files still being
+// uploaded for the first time aren't reported.
 func (s *MemoryFileStorage) GetFileMetadata(filename string) (FileMetadata, bool) {
-	result, exists := s.files[filename]
+	file, exists := s.lookup(filename)
 
 	if exists {
-		return *result, true
+		return file.metadata, true
 	} else {
 		return FileMetadata{}, false
 	}
 }
+
+// snapshot returns the current contents of a stored file. They are never
+// modified afterwards and may be read without holding the lock.
+func (s *MemoryFileStorage) snapshot(filename string) ([]byte, bool) {
+	file, exists := s.lookup(filename)
+	if !exists {
+		return nil, false
+	}
+
+	file.lastUsed.Store(time.Now().UnixNano())
+	return file.contents, true
+}
+
+// lookup returns a stored file. Looking up a file that has outlived TTL
+// removes the expired files.
+func (s *MemoryFileStorage) lookup(filename string) (*memoryFile, bool) {
+	s.mu.RLock()
+	file, exists := s.files[filename]
+	expired := exists && s.expired(file, time.Now())
+	s.mu.RUnlock()
+
+	if expired {
+		s.RemoveExpired()
+		return nil, false
+	}
+
+	return file, exists
+}
+
+func uploadNotStarted(op string, filename string) error {
+	return &fs.PathError{Op: op, Path: filename, Err: fs.ErrNotExist}
+}
+
+// sliceContents returns contents[start:end], with both bounds clamped to the
+// length of contents.
+func sliceContents(contents []byte, start int, end int) []byte {
+	if start > len(contents) {
+		start = len(contents)
+	}
+
+	if end > len(contents) {
+		end = len(contents)
+	}
+
+	if end < start {
+		end = start
+	}
+
+	return contents[start:end:end]
+}
+
+// stagedMemoryUpload is an upload to MemoryFileStorage that stays out of the
+// storage until it is committed.
+type stagedMemoryUpload struct {
+	storage *MemoryFileStorage
+	upload  memoryUpload
+	closed  bool
+}
+
+func (u *stagedMemoryUpload) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, errUploadClosed
+	}
+
+	u.storage.mu.Lock()
+	err := u.storage.reserve(u.upload.metadata.Filename, len(u.upload.contents), len(p))
+	u.storage.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	u.upload.contents = append(u.upload.contents, p...)
+	u.upload.metadata.LastBlockNum++
+	u.upload.metadata.Size = len(u.upload.contents)
+	return len(p), nil
+}
+
+func (u *stagedMemoryUpload) Commit() error {
+	if u.closed {
+		return errUploadClosed
+	}
+
+	u.closed = true
+
+	u.storage.mu.Lock()
+	defer u.storage.mu.Unlock()
+
+	return u.storage.publish(&u.upload)
+}
+
+func (u *stagedMemoryUpload) Abort() error {
+	if u.closed {
+		return errUploadClosed
+	}
+
+	u.closed = true
+
+	u.storage.mu.Lock()
+	u.storage.releaseUpload(len(u.upload.contents))
+	u.storage.mu.Unlock()
+
+	u.upload.contents = nil
+
+	fmt.Printf("Discarding upload of file: %s \n", u.upload.metadata.Filename)
+	return nil
+}
+
+func (u *stagedMemoryUpload) Close() error {
+	if u.closed {
+		return nil
+	}
+
+	return u.Abort()
+}
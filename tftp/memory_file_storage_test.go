@@ -0,0 +1,128 @@
+package tftp
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func uploadToMemoryStorage(storage *MemoryFileStorage, filename string, contents string) {
+	storage.StartNewUpload(filename)
+	storage.AppendData(filename, 1, []byte(contents))
+	storage.CompleteUpload(filename)
+}
+
+func readMemoryStorage(t *testing.T, storage *MemoryFileStorage, filename string, start int, end int) []byte {
+	data, err := storage.ReadFileBytes(filename, start, end)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestMemoryStorageHidesIncompleteUploads(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+
+	storage.StartNewUpload("file")
+	storage.AppendData("file", 1, []byte("half"))
+
+	_, exists := storage.GetFileMetadata("file")
+	assert.False(t, exists)
+	_, err := storage.ReadFileBytes("file", 0, 512)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	storage.AppendData("file", 2, []byte(" done"))
+	storage.CompleteUpload("file")
+
+	metadata, exists := storage.GetFileMetadata("file")
+	assert.True(t, exists)
+	assert.True(t, metadata.IsComplete)
+	assert.Equal(t, 9, metadata.Size)
+	assert.Equal(t, 2, metadata.LastBlockNum)
+	assert.Equal(t, []byte("half done"), readMemoryStorage(t, storage, "file", 0, 512))
+}
+
+func TestMemoryStorageKeepsOldContentsDuringOverwrite(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "file", "old contents")
+
+	storage.StartNewUpload("file")
+	storage.AppendData("file", 1, []byte("new"))
+
+	metadata, exists := storage.GetFileMetadata("file")
+	assert.True(t, exists)
+	assert.Equal(t, 12, metadata.Size)
+	assert.Equal(t, []byte("old contents"), readMemoryStorage(t, storage, "file", 0, 512))
+
+	reader, exists := newStorageReader(storage, "file")
+	assert.True(t, exists)
+	storage.AppendData("file", 2, []byte(" contents"))
+	storage.CompleteUpload("file")
+
+	contents, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old contents"), contents)
+	assert.Equal(t, []byte("new contents"), readMemoryStorage(t, storage, "file", 0, 512))
+}
+
+func TestMemoryStorageReadFileBytesDoesNotAlias(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "file", "Hello World")
+
+	data := readMemoryStorage(t, storage, "file", 0, 5)
+	_ = append(data, '!')
+	data[0] = 'J'
+
+	assert.Equal(t, []byte("Hello World"), readMemoryStorage(t, storage, "file", 0, 512))
+	assert.Equal(t, []byte("World"), readMemoryStorage(t, storage, "file", 6, 512))
+	assert.Empty(t, readMemoryStorage(t, storage, "file", 20, 30))
+}
+
+func TestMemoryStorageConcurrentUse(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		filename := fmt.Sprintf("file-%d", i)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			uploadToMemoryStorage(storage, filename, "contents")
+		}()
+		go func() {
+			defer wg.Done()
+			if _, exists := storage.GetFileMetadata(filename); exists {
+				assert.Equal(t, []byte("contents"), readMemoryStorage(t, storage, filename, 0, 512))
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestMemoryStorageStagedUploads(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "file", "old contents")
+
+	first, _ := storage.createUpload("file")
+	second, _ := storage.createUpload("file")
+
+	first.Write([]byte("first"))
+	second.Write([]byte("second"))
+	assert.Equal(t, []byte("old contents"), readMemoryStorage(t, storage, "file", 0, 512))
+
+	assert.NoError(t, second.Abort())
+	assert.Equal(t, []byte("old contents"), readMemoryStorage(t, storage, "file", 0, 512))
+
+	assert.NoError(t, first.Commit())
+	assert.NoError(t, first.Close())
+	assert.Equal(t, []byte("first"), readMemoryStorage(t, storage, "file", 0, 512))
+
+	_, err := second.Write([]byte("late"))
+	assert.ErrorIs(t, err, errUploadClosed)
+	assert.ErrorIs(t, second.Commit(), errUploadClosed)
+	assert.Equal(t, []byte("first"), readMemoryStorage(t, storage, "file", 0, 512))
+}
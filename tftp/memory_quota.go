@@ -0,0 +1,210 @@
+package tftp
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// EvictionPolicy decides whether MemoryFileStorage removes stored files to
+// make room for new uploads.
+type EvictionPolicy int
+
+const (
+	// EvictNone refuses uploads that would exceed the limits.
+	EvictNone EvictionPolicy = iota
+	// EvictLeastRecentlyUsed removes the files read or stored the longest ago
+	// until the upload fits.
+	EvictLeastRecentlyUsed
+)
+
+// quotaError is an upload exceeding the limits of MemoryFileStorage, reported
+// to clients as ErrDiskFull.
+type quotaError string
+
+func (e quotaError) Error() string {
+	return string(e)
+}
+
+func (e quotaError) Unwrap() error {
+	return ErrDiskFull
+}
+
+const (
+	// errFileTooLarge is returned for uploads over MemoryFileStorage.MaxFileSize.
+	errFileTooLarge quotaError = "file too large for the memory storage"
+	// errStorageFull is returned for uploads that would exceed
+	// MemoryFileStorage.MaxBytes or MaxFiles.
+	errStorageFull quotaError = "memory storage is full"
+)
+
+// Used returns the number of bytes held by stored files and uploads in progress.
+func (s *MemoryFileStorage) Used() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.used
+}
+
+// reserve accounts for n more bytes of an upload of filename already holding
+// size bytes. It must be called with s.mu held.
+func (s *MemoryFileStorage) reserve(filename string, size int, n int) error {
+	if s.MaxFileSize > 0 && size+n > s.MaxFileSize {
+		return &fs.PathError{Op: "write", Path: filename, Err: errFileTooLarge}
+	}
+
+	s.removeExpired()
+
+	// Evicting stored files can't make room taken by uploads in progress, so
+	// nothing is evicted for an upload that wouldn't fit anyway.
+	if s.MaxBytes > 0 && s.uploading+int64(n) > s.MaxBytes {
+		return &fs.PathError{Op: "write", Path: filename, Err: errStorageFull}
+	}
+
+	for s.MaxBytes > 0 && s.used+int64(n) > s.MaxBytes {
+		if !s.evict() {
+			return &fs.PathError{Op: "write", Path: filename, Err: errStorageFull}
+		}
+	}
+
+	s.used += int64(n)
+	s.uploading += int64(n)
+	return nil
+}
+
+// releaseUpload gives back the bytes of a discarded upload. It must be called
+// with s.mu held.
+func (s *MemoryFileStorage) releaseUpload(n int) {
+	s.used -= int64(n)
+	s.uploading -= int64(n)
+}
+
+// checkUploadSize tells whether an upload of size bytes, as declared by the
+// client, can fit under the limits.
+func (s *MemoryFileStorage) checkUploadSize(filename string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.MaxFileSize > 0 && size > int64(s.MaxFileSize) {
+		return &fs.PathError{Op: "create", Path: filename, Err: errFileTooLarge}
+	}
+
+	if s.MaxBytes <= 0 {
+		return nil
+	}
+
+	s.removeExpired()
+
+	available := s.MaxBytes - s.used
+	if s.Eviction == EvictLeastRecentlyUsed {
+		available = s.MaxBytes - s.uploading
+	}
+
+	if size > available {
+		return &fs.PathError{Op: "create", Path: filename, Err: errStorageFull}
+	}
+
+	return nil
+}
+
+// checkFileCount makes sure storing filename doesn't exceed MaxFiles. It must
+// be called with s.mu held.
+func (s *MemoryFileStorage) checkFileCount(filename string) error {
+	if s.MaxFiles <= 0 {
+		return nil
+	}
+
+	s.removeExpired()
+
+	if _, exists := s.files[filename]; exists {
+		return nil
+	}
+
+	for len(s.files) >= s.MaxFiles {
+		if !s.evict() {
+			return &fs.PathError{Op: "create", Path: filename, Err: errStorageFull}
+		}
+	}
+
+	return nil
+}
+
+// evict removes the least recently used file, if Eviction allows it. It must
+// be called with s.mu held.
+func (s *MemoryFileStorage) evict() bool {
+	if s.Eviction != EvictLeastRecentlyUsed {
+		return false
+	}
+
+	var oldest *memoryFile
+
+	for _, file := range s.files {
+		if oldest == nil || file.lastUsed.Load() < oldest.lastUsed.Load() {
+			oldest = file
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	fmt.Printf("Evicting file: %s \n", oldest.metadata.Filename)
+	s.remove(oldest.metadata.Filename)
+	return true
+}
+
+// RemoveExpired removes the files stored for longer than TTL. Expired files
+// are no longer served, but stay in memory until they are looked up, a file
+// is uploaded, or RemoveExpired is called.
+func (s *MemoryFileStorage) RemoveExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired()
+}
+
+// RemoveExpiredEvery calls RemoveExpired every interval until ctx is done.
+func (s *MemoryFileStorage) RemoveExpiredEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RemoveExpired()
+		}
+	}
+}
+
+// removeExpired removes the files stored for longer than TTL. It must be
+// called with s.mu held.
+func (s *MemoryFileStorage) removeExpired() {
+	if s.TTL <= 0 {
+		return
+	}
+
+	now := time.Now()
+
+	for filename, file := range s.files {
+		if s.expired(file, now) {
+			fmt.Printf("Expiring file: %s \n", filename)
+			s.remove(filename)
+		}
+	}
+}
+
+// expired reports whether a file has outlived TTL.
+func (s *MemoryFileStorage) expired(file *memoryFile, now time.Time) bool {
+	return s.TTL > 0 && now.Sub(file.stored) >= s.TTL
+}
+
+// remove deletes a stored file. It must be called with s.mu held.
+func (s *MemoryFileStorage) remove(filename string) {
+	if file, exists := s.files[filename]; exists {
+		s.used -= int64(len(file.contents))
+		delete(s.files, filename)
+	}
+}
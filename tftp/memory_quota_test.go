@@ -0,0 +1,209 @@
+package tftp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stageUpload writes contents to a new staged upload of storage.
+func stageUpload(t *testing.T, storage *MemoryFileStorage, filename string, contents string) (Upload, error) {
+	upload, err := storage.createUpload(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = upload.Write([]byte(contents))
+	return upload, err
+}
+
+func TestMemoryStorageRejectsFilesOverSizeLimit(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxFileSize = 8
+
+	upload, err := stageUpload(t, storage, "file", "12345678")
+	assert.NoError(t, err)
+	_, err = upload.Write([]byte("9"))
+	assert.ErrorIs(t, err, errFileTooLarge)
+	assert.Equal(t, ErrDiskFull, storageErrorCode(err))
+
+	assert.NoError(t, upload.Close())
+	assert.Equal(t, int64(0), storage.Used())
+}
+
+func TestMemoryStorageCountsUploadsInProgress(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxBytes = 10
+
+	first, err := stageUpload(t, storage, "first", "123456")
+	assert.NoError(t, err)
+	_, err = stageUpload(t, storage, "second", "123456")
+	assert.ErrorIs(t, err, errStorageFull)
+	assert.Equal(t, ErrDiskFull, storageErrorCode(err))
+
+	assert.NoError(t, first.Abort())
+	assert.Equal(t, int64(0), storage.Used())
+
+	second, err := stageUpload(t, storage, "second", "123456")
+	assert.NoError(t, err)
+	assert.NoError(t, second.Commit())
+	assert.Equal(t, int64(6), storage.Used())
+
+	// Replacing a file frees the old contents once the new ones are stored.
+	second, err = stageUpload(t, storage, "second", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), storage.Used())
+	assert.NoError(t, second.Commit())
+	assert.Equal(t, int64(4), storage.Used())
+}
+
+func TestMemoryStorageLimitsFileCount(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxFiles = 2
+	time.Sleep(time.Millisecond)
+	uploadToMemoryStorage(storage, "first", "1")
+	uploadToMemoryStorage(storage, "second", "2")
+
+	_, err := storage.createUpload("third")
+	assert.ErrorIs(t, err, errStorageFull)
+
+	// Existing files can still be overwritten.
+	upload, err := stageUpload(t, storage, "first", "one")
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Commit())
+	assert.Equal(t, []byte("one"), readMemoryStorage(t, storage, "first", 0, 512))
+}
+
+func TestMemoryStorageEvictsLeastRecentlyUsedFiles(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxBytes = 10
+	storage.Eviction = EvictLeastRecentlyUsed
+
+	uploadToMemoryStorage(storage, "first", "1234")
+	time.Sleep(time.Millisecond)
+	uploadToMemoryStorage(storage, "second", "1234")
+	time.Sleep(time.Millisecond)
+	readMemoryStorage(t, storage, "first", 0, 512)
+
+	upload, err := stageUpload(t, storage, "third", "1234")
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Commit())
+
+	_, exists := storage.GetFileMetadata("second")
+	assert.False(t, exists)
+	_, exists = storage.GetFileMetadata("first")
+	assert.True(t, exists)
+	assert.Equal(t, int64(8), storage.Used())
+
+	// Files are evicted for the file count as well.
+	storage.MaxFiles = 2
+	time.Sleep(time.Millisecond)
+	uploadToMemoryStorage(storage, "fourth", "")
+
+	_, exists = storage.GetFileMetadata("first")
+	assert.False(t, exists)
+	_, exists = storage.GetFileMetadata("third")
+	assert.True(t, exists)
+}
+
+func TestMemoryStorageExpiresFiles(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.TTL = 50 * time.Millisecond
+	uploadToMemoryStorage(storage, "file", "contents")
+	uploadToMemoryStorage(storage, "other", "1")
+
+	assert.Equal(t, []byte("contents"), readMemoryStorage(t, storage, "file", 0, 512))
+	time.Sleep(100 * time.Millisecond)
+
+	// Looking up an expired file frees every expired file, without waiting for an upload.
+	_, exists := storage.GetFileMetadata("file")
+	assert.False(t, exists)
+	assert.Equal(t, int64(0), storage.Used())
+
+	uploadToMemoryStorage(storage, "file", "contents")
+	time.Sleep(100 * time.Millisecond)
+
+	storage.RemoveExpired()
+	assert.Equal(t, int64(0), storage.Used())
+}
+
+func TestMemoryStorageDoesNotEvictForUploadsThatCannotFit(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxBytes = 100
+	storage.Eviction = EvictLeastRecentlyUsed
+
+	for _, filename := range []string{"first", "second", "third"} {
+		uploadToMemoryStorage(storage, filename, string(make([]byte, 30)))
+	}
+
+	_, err := stageUpload(t, storage, "large", string(make([]byte, 150)))
+	assert.ErrorIs(t, err, errStorageFull)
+
+	// Nor for uploads that can't fit next to the uploads in progress.
+	_, err = stageUpload(t, storage, "small", string(make([]byte, 10)))
+	assert.NoError(t, err)
+	_, err = stageUpload(t, storage, "other", string(make([]byte, 95)))
+	assert.ErrorIs(t, err, errStorageFull)
+
+	for _, filename := range []string{"first", "second", "third"} {
+		_, exists := storage.GetFileMetadata(filename)
+		assert.True(t, exists, filename)
+	}
+	assert.Equal(t, int64(100), storage.Used())
+}
+
+func TestMemoryStorageChecksDeclaredUploadSize(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	storage.MaxBytes = 100
+	storage.MaxFileSize = 80
+	uploadToMemoryStorage(storage, "file", string(make([]byte, 50)))
+
+	adapter := NewBlockStorageAdapter(storage).(sizeLimitedStorage)
+
+	assert.NoError(t, adapter.checkUploadSize("new", 50))
+	assert.ErrorIs(t, adapter.checkUploadSize("new", 81), errFileTooLarge)
+	assert.ErrorIs(t, adapter.checkUploadSize("new", 51), errStorageFull)
+
+	storage.Eviction = EvictLeastRecentlyUsed
+	assert.NoError(t, adapter.checkUploadSize("new", 80))
+}
+
+func TestWriteDeclaredOverMemoryLimitIsRejected(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	memory_storage.MaxFileSize = 1000
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: "firmware.bin",
+		Mode:     "octet",
+		Options:  []Option{{Name: "tsize", Value: "1001"}},
+	})
+	assertReceivedError(t, conn, ErrDiskFull)
+
+	assert.Empty(t, tftp_server.Transfers())
+}
+
+func TestWriteOverMemoryLimitReportsDiskFull(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	memory_storage.MaxBytes = 12
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	data_addr := startPartialUpload(t, conn, server_addr, "firmware.bin")
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 2, Data: []byte("ware v2!")})
+	assertReceivedError(t, conn, ErrDiskFull)
+
+	waitForTransfers(t, tftp_server)
+	_, exists := memory_storage.GetFileMetadata("firmware.bin")
+	assert.False(t, exists)
+	assert.Equal(t, int64(0), memory_storage.Used())
+}
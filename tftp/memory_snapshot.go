@@ -0,0 +1,202 @@
+package tftp
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// PAX record holding FileMetadata.LastBlockNum in snapshots.
+const snapshotLastBlockNumRecord = "TFTP.LastBlockNum"
+
+// WriteSnapshot writes the stored files to w as a tar archive, with one entry
+// per file named after it. Uploads in progress are left out.
+func (s *MemoryFileStorage) WriteSnapshot(w io.Writer) error {
+	s.mu.RLock()
+
+	now := time.Now()
+	files := make([]*memoryFile, 0, len(s.files))
+
+	for _, file := range s.files {
+		if !s.expired(file, now) {
+			files = append(files, file)
+		}
+	}
+
+	// The contents are never modified, so they can be written without the lock.
+	s.mu.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].metadata.Filename < files[j].metadata.Filename
+	})
+
+	archive := tar.NewWriter(w)
+
+	for _, file := range files {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.metadata.Filename,
+			Size:     int64(len(file.contents)),
+			Mode:     int64(dirFileMode),
+			ModTime:  file.stored,
+			Format:   tar.FormatPAX,
+			PAXRecords: map[string]string{
+				snapshotLastBlockNumRecord: strconv.Itoa(file.metadata.LastBlockNum),
+			},
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if _, err := archive.Write(file.contents); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// ReadSnapshot stores the files of a tar archive written by WriteSnapshot,
+// replacing files with the same names. Nothing is stored unless the whole
+// archive can be read and fits under the limits of the storage.
+func (s *MemoryFileStorage) ReadSnapshot(r io.Reader) error {
+	var uploads []*memoryUpload
+	var stored []time.Time
+
+	archive := tar.NewReader(r)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("snapshot entry %q is not a regular file", header.Name)
+		}
+
+		contents, err := io.ReadAll(archive)
+		if err != nil {
+			return err
+		}
+
+		lastBlockNum := 0
+		if value, exists := header.PAXRecords[snapshotLastBlockNumRecord]; exists {
+			if lastBlockNum, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("snapshot entry %q has invalid %s: %q", header.Name, snapshotLastBlockNumRecord, value)
+			}
+		}
+
+		uploads = append(uploads, &memoryUpload{
+			metadata: FileMetadata{
+				Filename:     header.Name,
+				LastBlockNum: lastBlockNum,
+				Size:         len(contents),
+			},
+			contents: contents,
+		})
+		stored = append(stored, header.ModTime)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Restoring is all or nothing: the previous files and byte count come
+	// back if a file doesn't fit.
+	previousFiles := make(map[string]*memoryFile, len(s.files))
+	for filename, file := range s.files {
+		previousFiles[filename] = file
+	}
+	previousUsed := s.used
+
+	for i, upload := range uploads {
+		err := s.reserve(upload.metadata.Filename, 0, len(upload.contents))
+		if err == nil {
+			err = s.publish(upload)
+		}
+
+		if err != nil {
+			s.files = previousFiles
+			s.used = previousUsed
+			return err
+		}
+
+		// Files keep the time they were first stored, so TTL carries on
+		// across restarts.
+		if !stored[i].IsZero() {
+			s.files[upload.metadata.Filename].stored = stored[i]
+		}
+	}
+
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to the file at path. The snapshot is written
+// to a temporary file first and renamed over path, so a crash while saving
+// leaves the previous snapshot intact.
+func (s *MemoryFileStorage) SaveSnapshot(path string) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteSnapshot(temp)
+	if err == nil {
+		err = temp.Chmod(dirFileMode)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+
+	return err
+}
+
+// LoadSnapshot reads a snapshot from the file at path. An error wrapping
+// fs.ErrNotExist is returned if there is no such file.
+func (s *MemoryFileStorage) LoadSnapshot(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.ReadSnapshot(file)
+}
+
+// SaveSnapshotEvery saves a snapshot to path every interval until ctx is
+// done. Failures are logged and retried at the next interval.
+func (s *MemoryFileStorage) SaveSnapshotEvery(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.SaveSnapshot(path); err != nil {
+				fmt.Printf("Error when saving snapshot to %s: %s \n", path, err)
+			}
+		}
+	}
+}
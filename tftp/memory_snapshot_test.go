@@ -0,0 +1,93 @@
+package tftp
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorageSnapshotRoundTrip(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "configs/switch.cfg", "hostname switch")
+	uploadToMemoryStorage(storage, "empty", "")
+	storage.StartNewUpload("in-progress")
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, storage.WriteSnapshot(&snapshot))
+
+	restored := CreateEmptyMemoryStorage()
+	assert.NoError(t, restored.ReadSnapshot(&snapshot))
+
+	assert.Equal(t, []byte("hostname switch"), readMemoryStorage(t, restored, "configs/switch.cfg", 0, 512))
+	metadata, exists := restored.GetFileMetadata("configs/switch.cfg")
+	assert.True(t, exists)
+	assert.Equal(t, FileMetadata{Filename: "configs/switch.cfg", IsComplete: true, LastBlockNum: 1, Size: 15}, metadata)
+
+	_, exists = restored.GetFileMetadata("empty")
+	assert.True(t, exists)
+	_, exists = restored.GetFileMetadata("in-progress")
+	assert.False(t, exists)
+	assert.Equal(t, int64(15), restored.Used())
+}
+
+func TestMemoryStorageSnapshotIsRestoredWhollyOrNotAtAll(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "first", "12345")
+	uploadToMemoryStorage(storage, "second", "12345")
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, storage.WriteSnapshot(&snapshot))
+
+	restored := CreateEmptyMemoryStorage()
+	restored.MaxBytes = 8
+	uploadToMemoryStorage(restored, "first", "old")
+
+	assert.ErrorIs(t, restored.ReadSnapshot(&snapshot), ErrDiskFull)
+	assert.Equal(t, []byte("old"), readMemoryStorage(t, restored, "first", 0, 512))
+	_, exists := restored.GetFileMetadata("second")
+	assert.False(t, exists)
+	assert.Equal(t, int64(3), restored.Used())
+
+	assert.Error(t, restored.ReadSnapshot(bytes.NewReader([]byte("not a tar archive, but long enough to hold a header"))))
+}
+
+func TestMemoryStorageSnapshotKeepsStoredTime(t *testing.T) {
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "file", "contents")
+	time.Sleep(100 * time.Millisecond)
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, storage.WriteSnapshot(&snapshot))
+
+	restored := CreateEmptyMemoryStorage()
+	restored.TTL = 50 * time.Millisecond
+	assert.NoError(t, restored.ReadSnapshot(&snapshot))
+
+	_, exists := restored.GetFileMetadata("file")
+	assert.False(t, exists)
+}
+
+func TestMemoryStorageSaveAndLoadSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.tar")
+
+	restored := CreateEmptyMemoryStorage()
+	assert.ErrorIs(t, restored.LoadSnapshot(path), fs.ErrNotExist)
+
+	storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(storage, "file", "contents")
+	assert.NoError(t, storage.SaveSnapshot(path))
+
+	uploadToMemoryStorage(storage, "file", "new contents")
+	assert.NoError(t, storage.SaveSnapshot(path))
+
+	assert.NoError(t, restored.LoadSnapshot(path))
+	assert.Equal(t, []byte("new contents"), readMemoryStorage(t, restored, "file", 0, 512))
+
+	entries, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path}, entries)
+}
@@ -0,0 +1,203 @@
+package tftp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryNetwork is an in-memory packet transport for running the server
+// without sockets. Packets sent to an address nobody listens on are dropped.
+type memoryNetwork struct {
+	mu    sync.Mutex
+	conns map[string]*memoryConn
+	// nextPort is the port given to the next address listening on port 0.
+	nextPort int
+}
+
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+type memoryPacket struct {
+	data []byte
+	from net.Addr
+}
+
+type memoryConn struct {
+	network   *memoryNetwork
+	addr      memoryAddr
+	packets   chan memoryPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func newMemoryNetwork() *memoryNetwork {
+	return &memoryNetwork{conns: map[string]*memoryConn{}, nextPort: 1024}
+}
+
+func (n *memoryNetwork) ListenPacket(network string, address string) (net.PacketConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if host, port, err := net.SplitHostPort(address); err == nil && port == "0" {
+		address = net.JoinHostPort(host, strconv.Itoa(n.nextPort))
+		n.nextPort++
+	}
+
+	if _, taken := n.conns[address]; taken {
+		return nil, errors.New("address already in use")
+	}
+
+	conn := &memoryConn{
+		network: n,
+		addr:    memoryAddr(address),
+		packets: make(chan memoryPacket, 64),
+		closed:  make(chan struct{}),
+	}
+	n.conns[address] = conn
+	return conn, nil
+}
+
+func (c *memoryConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.packets:
+		return copy(p, packet.data), packet.from, nil
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *memoryConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.network.mu.Lock()
+	target, ok := c.network.conns[addr.String()]
+	c.network.mu.Unlock()
+
+	if ok {
+		select {
+		case target.packets <- memoryPacket{data: append([]byte(nil), p...), from: c.addr}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *memoryConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.network.mu.Lock()
+		delete(c.network.conns, string(c.addr))
+		c.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr { return c.addr }
+
+func (c *memoryConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+func (c *memoryConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func exchangePacket(t *testing.T, conn net.PacketConn, addr net.Addr, packet interface{ MarshalBinary() ([]byte, error) }) ([]byte, net.Addr) {
+	data, err := packet.MarshalBinary()
+	assert.NoError(t, err)
+
+	_, err = conn.WriteTo(data, addr)
+	assert.NoError(t, err)
+
+	buffer := make([]byte, MaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, from, err := conn.ReadFrom(buffer)
+	assert.NoError(t, err, "Expected to receive a packet.")
+
+	return buffer[:n], from
+}
+
+func TestServeOverInjectedTransport(t *testing.T) {
+	network := newMemoryNetwork()
+	tftp_server := &TftpServer{
+		ListenPacket: network.ListenPacket,
+		fileStorage:  NewBlockStorageAdapter(CreateEmptyMemoryStorage()),
+	}
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+	})
+
+	listener, err := network.ListenPacket("memory", "server:69")
+	assert.NoError(t, err)
+
+	go tftp_server.Serve(context.Background(), listener)
+
+	client, err := network.ListenPacket("memory", "client:1024")
+	assert.NoError(t, err)
+	defer client.Close()
+
+	reply, data_addr := exchangePacket(t, client, listener.LocalAddr(), PacketRequest{Op: OpWrite, Filename: "file", Mode: "octet"})
+	assert.Equal(t, []byte("\x00\x04\x00\x00"), reply)
+	assert.NotEqual(t, listener.LocalAddr(), data_addr, "Expected the transfer to use its own socket.")
+
+	reply, _ = exchangePacket(t, client, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assert.Equal(t, []byte("\x00\x04\x00\x01"), reply)
+
+	reply, data_addr = exchangePacket(t, client, listener.LocalAddr(), PacketRequest{Op: OpRead, Filename: "file", Mode: "octet"})
+	assert.Equal(t, []byte("\x00\x03\x00\x01Hello World"), reply)
+
+	_, err = client.WriteTo([]byte("\x00\x04\x00\x01"), data_addr)
+	assert.NoError(t, err)
+}
+
+func TestTransferSocketFollowsListener(t *testing.T) {
+	tftp_server, mock_file_storage, _, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go tftp_server.Serve(context.Background(), listener)
+
+	conn, _ := createClientServerConnection(t, client_port, 0)
+	defer conn.Close()
+
+	sendPacket(t, conn, listener.LocalAddr().(*net.UDPAddr), PacketRequest{Op: OpRead, Filename: "existing-file", Mode: "octet"})
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+
+	assert.True(t, data_addr.IP.Equal(net.IPv4(127, 0, 0, 1)))
+	assert.NotEqual(t, listener.LocalAddr().(*net.UDPAddr).Port, data_addr.Port)
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+}
@@ -0,0 +1,223 @@
+// Code generated by mockery v2.38.0. DO NOT EDIT.
+
+package tftp
+
+import mock "github.com/stretchr/testify/mock"
+
+// MockUpload is an autogenerated mock type for the Upload type
+type MockUpload struct {
+	mock.Mock
+}
+
+type MockUpload_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *MockUpload) EXPECT() *MockUpload_Expecter {
+	return &MockUpload_Expecter{mock: &_m.Mock}
+}
+
+// Abort provides a mock function with given fields:
+func (_m *MockUpload) Abort() error {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Abort")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUpload_Abort_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Abort'
+type MockUpload_Abort_Call struct {
+	*mock.Call
+}
+
+// Abort is a helper method to define mock.On call
+func (_e *MockUpload_Expecter) Abort() *MockUpload_Abort_Call {
+	return &MockUpload_Abort_Call{Call: _e.mock.On("Abort")}
+}
+
+func (_c *MockUpload_Abort_Call) Run(run func()) *MockUpload_Abort_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockUpload_Abort_Call) Return(_a0 error) *MockUpload_Abort_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUpload_Abort_Call) RunAndReturn(run func() error) *MockUpload_Abort_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Close provides a mock function with given fields:
+func (_m *MockUpload) Close() error {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Close")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUpload_Close_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Close'
+type MockUpload_Close_Call struct {
+	*mock.Call
+}
+
+// Close is a helper method to define mock.On call
+func (_e *MockUpload_Expecter) Close() *MockUpload_Close_Call {
+	return &MockUpload_Close_Call{Call: _e.mock.On("Close")}
+}
+
+func (_c *MockUpload_Close_Call) Run(run func()) *MockUpload_Close_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockUpload_Close_Call) Return(_a0 error) *MockUpload_Close_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUpload_Close_Call) RunAndReturn(run func() error) *MockUpload_Close_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Commit provides a mock function with given fields:
+func (_m *MockUpload) Commit() error {
+	ret := _m.Called()
+
+	if len(ret) == 0 {
+		panic("no return value specified for Commit")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MockUpload_Commit_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Commit'
+type MockUpload_Commit_Call struct {
+	*mock.Call
+}
+
+// Commit is a helper method to define mock.On call
+func (_e *MockUpload_Expecter) Commit() *MockUpload_Commit_Call {
+	return &MockUpload_Commit_Call{Call: _e.mock.On("Commit")}
+}
+
+func (_c *MockUpload_Commit_Call) Run(run func()) *MockUpload_Commit_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run()
+	})
+	return _c
+}
+
+func (_c *MockUpload_Commit_Call) Return(_a0 error) *MockUpload_Commit_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MockUpload_Commit_Call) RunAndReturn(run func() error) *MockUpload_Commit_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Write provides a mock function with given fields: p
+func (_m *MockUpload) Write(p []byte) (int, error) {
+	ret := _m.Called(p)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Write")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func([]byte) (int, error)); ok {
+		return rf(p)
+	}
+	if rf, ok := ret.Get(0).(func([]byte) int); ok {
+		r0 = rf(p)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func([]byte) error); ok {
+		r1 = rf(p)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MockUpload_Write_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Write'
+type MockUpload_Write_Call struct {
+	*mock.Call
+}
+
+// Write is a helper method to define mock.On call
+//   - p []byte
+func (_e *MockUpload_Expecter) Write(p interface{}) *MockUpload_Write_Call {
+	return &MockUpload_Write_Call{Call: _e.mock.On("Write", p)}
+}
+
+func (_c *MockUpload_Write_Call) Run(run func(p []byte)) *MockUpload_Write_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].([]byte))
+	})
+	return _c
+}
+
+func (_c *MockUpload_Write_Call) Return(n int, err error) *MockUpload_Write_Call {
+	_c.Call.Return(n, err)
+	return _c
+}
+
+func (_c *MockUpload_Write_Call) RunAndReturn(run func([]byte) (int, error)) *MockUpload_Write_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// NewMockUpload creates a new instance of MockUpload. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewMockUpload(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *MockUpload {
+	mock := &MockUpload{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
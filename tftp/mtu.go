@@ -0,0 +1,87 @@
+package tftp
+
+import (
+	"net"
+)
+
+const (
+	ipv4HeaderSize int = 20
+	ipv6HeaderSize int = 40
+	udpHeaderSize  int = 8
+	// Opcode and block number preceding the payload of a DATA packet.
+	dataHeaderSize int = 4
+)
+
+// blockSizeLimit returns the largest block size the server agrees to, bounded
+// by the configured maximum and by the MTU of the listening interface.
+func (s *TftpServer) blockSizeLimit() int {
+	limit := maxBlockSize
+
+	if s.MaxBlockSize > 0 && s.MaxBlockSize < limit {
+		limit = s.MaxBlockSize
+	}
+
+	if s.mtuBlockSize > 0 && s.mtuBlockSize < limit {
+		limit = s.mtuBlockSize
+	}
+
+	if limit < minBlockSize {
+		limit = minBlockSize
+	}
+
+	return limit
+}
+
+// mtuBlockSize returns the largest block size that fits into a single IP
+// datagram on the interface owning ip, or 0 when the MTU can't be determined.
+func mtuBlockSize(ip net.IP) int {
+	mtu := interfaceMTU(ip)
+	if mtu == 0 {
+		return 0
+	}
+
+	headerSize := ipv6HeaderSize
+	if ip.To4() != nil {
+		headerSize = ipv4HeaderSize
+	}
+
+	return mtu - headerSize - udpHeaderSize - dataHeaderSize
+}
+
+// interfaceMTU looks up the MTU of the interface owning ip. For an unspecified
+// address the smallest MTU of all interfaces that are up is used, since the
+// transfer may go out through any of them.
+func interfaceMTU(ip net.IP) int {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+
+	smallest := 0
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		if ip.IsUnspecified() {
+			if iface.Flags&net.FlagLoopback == 0 && (smallest == 0 || iface.MTU < smallest) {
+				smallest = iface.MTU
+			}
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.MTU
+			}
+		}
+	}
+
+	return smallest
+}
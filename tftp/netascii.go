@@ -0,0 +1,128 @@
+package tftp
+
+import (
+	"io"
+)
+
+// netasciiReader encodes a local file as netascii (RFC 764) while it is read:
+// LF becomes CR LF and a bare CR becomes CR NUL. A sequence that doesn't fit
+// into the buffer passed to Read is carried over to the next call, so block
+// boundaries may fall anywhere in the encoded stream.
+type netasciiReader struct {
+	source   io.Reader
+	buffer   [512]byte
+	raw      []byte
+	carry    byte
+	hasCarry bool
+	err      error
+}
+
+func newNetasciiReader(source io.Reader) *netasciiReader {
+	return &netasciiReader{source: source}
+}
+
+func (r *netasciiReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if r.hasCarry {
+			p[n] = r.carry
+			r.hasCarry = false
+			n++
+			continue
+		}
+
+		if len(r.raw) == 0 {
+			if r.err != nil {
+				break
+			}
+
+			m, err := r.source.Read(r.buffer[:])
+			r.raw = r.buffer[:m]
+			r.err = err
+			continue
+		}
+
+		b := r.raw[0]
+		r.raw = r.raw[1:]
+
+		switch b {
+		case '\n':
+			p[n] = '\r'
+			r.carry, r.hasCarry = '\n', true
+		case '\r':
+			p[n] = '\r'
+			r.carry, r.hasCarry = 0, true
+		default:
+			p[n] = b
+		}
+		n++
+	}
+
+	if n == 0 && r.err != nil {
+		return 0, r.err
+	}
+
+	return n, nil
+}
+
+// netasciiWriter decodes netascii received from the wire before writing it
+// to target: CR LF becomes LF and CR NUL becomes CR. A CR ending one Write is
+// held back until the following byte arrives, since the sequence it starts
+// may be split across two blocks.
+type netasciiWriter struct {
+	target    io.Writer
+	pendingCR bool
+}
+
+func newNetasciiWriter(target io.Writer) *netasciiWriter {
+	return &netasciiWriter{target: target}
+}
+
+func (w *netasciiWriter) Write(p []byte) (int, error) {
+	decoded := make([]byte, 0, len(p)+1)
+
+	for _, b := range p {
+		if w.pendingCR {
+			w.pendingCR = false
+
+			switch b {
+			case '\n':
+				decoded = append(decoded, '\n')
+				continue
+			case 0:
+				decoded = append(decoded, '\r')
+				continue
+			default:
+				// Not valid netascii, keep the CR as it was sent.
+				decoded = append(decoded, '\r')
+			}
+		}
+
+		if b == '\r' {
+			w.pendingCR = true
+			continue
+		}
+
+		decoded = append(decoded, b)
+	}
+
+	if len(decoded) > 0 {
+		if _, err := w.target.Write(decoded); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes a CR held back at the end of the stream.
+func (w *netasciiWriter) Flush() error {
+	if !w.pendingCR {
+		return nil
+	}
+
+	w.pendingCR = false
+	_, err := w.target.Write([]byte{'\r'})
+	return err
+}
@@ -0,0 +1,90 @@
+package tftp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetasciiReaderEncodes(t *testing.T) {
+	reader := newNetasciiReader(bytes.NewReader([]byte("a\nb\rc\r\n")))
+
+	encoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a\r\nb\r\x00c\r\x00\r\n"), encoded)
+}
+
+func TestNetasciiReaderSplitsSequenceAcrossBlocks(t *testing.T) {
+	reader := newNetasciiReader(bytes.NewReader([]byte("abc\ndef")))
+
+	block := make([]byte, 4)
+	n, err := io.ReadFull(reader, block)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abc\r"), block[:n])
+
+	n, err = io.ReadFull(reader, block)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\ndef"), block[:n])
+
+	n, err = io.ReadFull(reader, block)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestNetasciiWriterDecodes(t *testing.T) {
+	var decoded bytes.Buffer
+	writer := newNetasciiWriter(&decoded)
+
+	writer.Write([]byte("a\r\nb\r\x00c"))
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, []byte("a\nb\rc"), decoded.Bytes())
+}
+
+func TestNetasciiWriterJoinsSequenceAcrossBlocks(t *testing.T) {
+	var decoded bytes.Buffer
+	writer := newNetasciiWriter(&decoded)
+
+	writer.Write([]byte("abc\r"))
+	assert.Equal(t, []byte("abc"), decoded.Bytes())
+
+	writer.Write([]byte("\ndef\r"))
+	writer.Write([]byte("\x00"))
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, []byte("abc\ndef\r"), decoded.Bytes())
+}
+
+func TestNetasciiWriterKeepsTrailingCR(t *testing.T) {
+	var decoded bytes.Buffer
+	writer := newNetasciiWriter(&decoded)
+
+	writer.Write([]byte("abc\r"))
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, []byte("abc\r"), decoded.Bytes())
+}
+
+func TestNetasciiRoundTrip(t *testing.T) {
+	original := []byte("first\nsecond\r\nthird\rfourth\n\n")
+
+	encoded, err := io.ReadAll(newNetasciiReader(bytes.NewReader(original)))
+	assert.NoError(t, err)
+
+	var decoded bytes.Buffer
+	writer := newNetasciiWriter(&decoded)
+	for len(encoded) > 0 {
+		// Odd sized blocks split the encoded sequences at every position.
+		size := 3
+		if len(encoded) < size {
+			size = len(encoded)
+		}
+		writer.Write(encoded[:size])
+		encoded = encoded[size:]
+	}
+	assert.NoError(t, writer.Flush())
+
+	assert.Equal(t, original, decoded.Bytes())
+}
@@ -13,11 +13,12 @@ func _() {
 	_ = x[OpData-3]
 	_ = x[OpAck-4]
 	_ = x[OpError-5]
+	_ = x[OpOAck-6]
 }
 
-const _Op_name = "OpReadOpWriteOpDataOpAckOpError"
+const _Op_name = "OpReadOpWriteOpDataOpAckOpErrorOpOAck"
 
-var _Op_index = [...]uint8{0, 6, 13, 19, 24, 31}
+var _Op_index = [...]uint8{0, 6, 13, 19, 24, 31, 37}
 
 func (i Op) String() string {
 	i -= 1
@@ -0,0 +1,194 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultBlockSize int = 512
+	// Bounds of the blksize option (RFC 2348).
+	minBlockSize int = 8
+	maxBlockSize int = 65464
+	// Bounds of the timeout option in seconds (RFC 2349).
+	minTimeoutSeconds int = 1
+	maxTimeoutSeconds int = 255
+	// Bounds of the windowsize option (RFC 7440).
+	minWindowSize int = 1
+	maxWindowSize int = 65535
+	// Largest window agreed to when TftpServer.MaxWindowSize isn't set, it bounds
+	// the memory held by the unacknowledged blocks of a transfer.
+	defaultMaxWindowSize int = 64
+)
+
+// transferOptions holds the parameters negotiated for a single transfer.
+type transferOptions struct {
+	// netascii is set when the file is transferred in netascii mode.
+	netascii  bool
+	blockSize int
+	// timeout overrides the server's retransmission interval when non-zero.
+	timeout time.Duration
+	// transferSize is the size of the file declared by the client in a WRQ, or -1.
+	transferSize int
+	// fileSize is the size of the file sent in response to a RRQ, or -1.
+	fileSize int64
+	// windowSize is the number of DATA packets sent before waiting for an ACK.
+	windowSize int
+}
+
+// optionHandler validates the value requested by the client and applies it
+// to the transfer. It returns the value to acknowledge in the OACK; ok is
+// false when the option should be left out of the OACK.
+type optionHandler func(s *TftpServer, request PacketRequest, value string, options *transferOptions) (acked string, ok bool, err error)
+
+// optionHandlers lists the options understood by the server, keyed by their
+// lowercase name. Options not listed here are ignored, as RFC 2347 requires.
+var optionHandlers = map[string]optionHandler{
+	"blksize":    negotiateBlockSize,
+	"timeout":    negotiateTimeout,
+	"tsize":      negotiateTransferSize,
+	"windowsize": negotiateWindowSize,
+}
+
+// isSupportedMode reports whether a transfer mode (case-insensitive) can be served.
+func isSupportedMode(mode string) bool {
+	return strings.EqualFold(mode, "octet") || strings.EqualFold(mode, "netascii")
+}
+
+func defaultTransferOptions() transferOptions {
+	return transferOptions{
+		blockSize:    defaultBlockSize,
+		transferSize: -1,
+		fileSize:     -1,
+		windowSize:   1,
+	}
+}
+
+// negotiateOptions processes the options of a request and returns the
+// parameters to use for the transfer along with the options to acknowledge.
+// fileSize is the size of the requested file for a RRQ, or -1. An empty list
+// of acknowledged options means no OACK should be sent.
+func (s *TftpServer) negotiateOptions(request PacketRequest, fileSize int64) (transferOptions, []Option, error) {
+	options := defaultTransferOptions()
+	options.netascii = strings.EqualFold(request.Mode, "netascii")
+	options.fileSize = fileSize
+	var acked []Option
+
+	for _, option := range request.Options {
+		name := strings.ToLower(option.Name)
+
+		handler, known := optionHandlers[name]
+		if !known {
+			continue
+		}
+
+		value, ok, err := handler(s, request, option.Value, &options)
+		if err != nil {
+			return options, nil, err
+		}
+
+		if ok {
+			acked = append(acked, Option{Name: name, Value: value})
+		}
+	}
+
+	return options, acked, nil
+}
+
+// negotiationErrorCode picks the TFTP error sent when negotiation fails. Handlers
+// may wrap a specific ErrorCode, anything else is reported as ErrOptionNegotiation.
+func negotiationErrorCode(err error) ErrorCode {
+	var code ErrorCode
+	if errors.As(err, &code) {
+		return code
+	}
+
+	return ErrOptionNegotiation
+}
+
+// negotiateBlockSize accepts the requested block size, lowered to the largest
+// size the server allows for the transfer (RFC 2348).
+func negotiateBlockSize(s *TftpServer, request PacketRequest, value string, options *transferOptions) (string, bool, error) {
+	blockSize, err := strconv.Atoi(value)
+
+	if err != nil || blockSize < minBlockSize || blockSize > maxBlockSize {
+		return "", false, fmt.Errorf("invalid blksize: %q", value)
+	}
+
+	if limit := s.blockSizeLimit(); blockSize > limit {
+		blockSize = limit
+	}
+
+	options.blockSize = blockSize
+	return strconv.Itoa(blockSize), true, nil
+}
+
+// negotiateTimeout accepts the retransmission interval requested by the client (RFC 2349).
+func negotiateTimeout(s *TftpServer, request PacketRequest, value string, options *transferOptions) (string, bool, error) {
+	seconds, err := strconv.Atoi(value)
+
+	if err != nil || seconds < minTimeoutSeconds || seconds > maxTimeoutSeconds {
+		return "", false, fmt.Errorf("invalid timeout: %q", value)
+	}
+
+	options.timeout = time.Duration(seconds) * time.Second
+	return strconv.Itoa(seconds), true, nil
+}
+
+// negotiateTransferSize reports the size of the requested file for an octet RRQ, and
+// checks the size declared by the client against the upload limit for a WRQ
+// (RFC 2349).
+func negotiateTransferSize(s *TftpServer, request PacketRequest, value string, options *transferOptions) (string, bool, error) {
+	size, err := strconv.Atoi(value)
+
+	if err != nil || size < 0 {
+		return "", false, fmt.Errorf("invalid tsize: %q", value)
+	}
+
+	if request.Op == OpRead {
+		// Converting a file to netascii makes it longer by an unknown amount,
+		// so its size isn't reported, like tftp-hpa does.
+		if options.fileSize < 0 || options.netascii {
+			return "", false, nil
+		}
+
+		return strconv.FormatInt(options.fileSize, 10), true, nil
+	}
+
+	if s.MaxUploadSize > 0 && size > s.MaxUploadSize {
+		return "", false, fmt.Errorf("file of %d bytes exceeds the upload limit of %d bytes: %w", size, s.MaxUploadSize, ErrDiskFull)
+	}
+
+	options.transferSize = size
+	return value, true, nil
+}
+
+// negotiateWindowSize accepts the number of blocks the client wants to receive
+// between ACKs, lowered to TftpServer.MaxWindowSize (RFC 7440). Only downloads
+// are sent in windows, so the option is left unacknowledged for a WRQ.
+func negotiateWindowSize(s *TftpServer, request PacketRequest, value string, options *transferOptions) (string, bool, error) {
+	windowSize, err := strconv.Atoi(value)
+
+	if err != nil || windowSize < minWindowSize || windowSize > maxWindowSize {
+		return "", false, fmt.Errorf("invalid windowsize: %q", value)
+	}
+
+	if request.Op != OpRead {
+		return "", false, nil
+	}
+
+	limit := s.MaxWindowSize
+	if limit <= 0 {
+		limit = defaultMaxWindowSize
+	}
+
+	if windowSize > limit {
+		windowSize = limit
+	}
+
+	options.windowSize = windowSize
+	return strconv.Itoa(windowSize), true, nil
+}
@@ -0,0 +1,115 @@
+package tftp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateBlockSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBlockSize  int
+		requested     string
+		expectedSize  int
+		expectedAcked []Option
+		expectError   bool
+	}{
+		{"no options", 0, "", defaultBlockSize, nil, false},
+		{"accepted", 0, "1428", 1428, []Option{{"blksize", "1428"}}, false},
+		{"lowered to configured maximum", 1024, "1428", 1024, []Option{{"blksize", "1024"}}, false},
+		{"smallest", 0, "8", 8, []Option{{"blksize", "8"}}, false},
+		{"too small", 0, "7", 0, nil, true},
+		{"too large", 0, "65465", 0, nil, true},
+		{"not a number", 0, "large", 0, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := TftpServer{MaxBlockSize: test.maxBlockSize}
+			request := PacketRequest{Op: OpRead, Filename: "file", Mode: "octet"}
+			if test.requested != "" {
+				request.Options = []Option{{"blksize", test.requested}}
+			}
+
+			options, acked, err := server.negotiateOptions(request, -1)
+
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedSize, options.blockSize)
+			assert.Equal(t, test.expectedAcked, acked)
+		})
+	}
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	server := TftpServer{}
+
+	options, acked, err := server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"timeout", "7"}}}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 7*time.Second, options.timeout)
+	assert.Equal(t, []Option{{"timeout", "7"}}, acked)
+
+	_, _, err = server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"timeout", "0"}}}, -1)
+	assert.Error(t, err)
+
+	_, _, err = server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"timeout", "256"}}}, -1)
+	assert.Error(t, err)
+}
+
+func TestNegotiateTransferSizeForRead(t *testing.T) {
+	server := TftpServer{}
+
+	_, acked, err := server.negotiateOptions(PacketRequest{Op: OpRead, Filename: "existing-file", Options: []Option{{"tsize", "0"}}}, 1234)
+	assert.NoError(t, err)
+	assert.Equal(t, []Option{{"tsize", "1234"}}, acked)
+}
+
+func TestNegotiateTransferSizeForNetasciiReadIsLeftOut(t *testing.T) {
+	server := TftpServer{}
+
+	// The size on the wire isn't known before the file is converted to netascii.
+	_, acked, err := server.negotiateOptions(PacketRequest{Op: OpRead, Filename: "existing-file", Mode: "netascii", Options: []Option{{"tsize", "0"}}}, 1234)
+	assert.NoError(t, err)
+	assert.Empty(t, acked)
+}
+
+func TestNegotiateTransferSizeForWrite(t *testing.T) {
+	server := TftpServer{MaxUploadSize: 1000}
+
+	options, acked, err := server.negotiateOptions(PacketRequest{Op: OpWrite, Filename: "new-file", Options: []Option{{"tsize", "1000"}}}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, options.transferSize)
+	assert.Equal(t, []Option{{"tsize", "1000"}}, acked)
+
+	_, _, err = server.negotiateOptions(PacketRequest{Op: OpWrite, Filename: "new-file", Options: []Option{{"tsize", "1001"}}}, -1)
+	assert.ErrorIs(t, err, ErrDiskFull)
+	assert.Equal(t, ErrDiskFull, negotiationErrorCode(err))
+}
+
+func TestNegotiateWindowSize(t *testing.T) {
+	server := TftpServer{MaxWindowSize: 16}
+
+	options, acked, err := server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"windowsize", "8"}}}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, options.windowSize)
+	assert.Equal(t, []Option{{"windowsize", "8"}}, acked)
+
+	options, acked, err = server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"windowsize", "32"}}}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, options.windowSize)
+	assert.Equal(t, []Option{{"windowsize", "16"}}, acked)
+
+	options, acked, err = server.negotiateOptions(PacketRequest{Op: OpWrite, Options: []Option{{"windowsize", "8"}}}, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, options.windowSize)
+	assert.Empty(t, acked)
+
+	_, _, err = server.negotiateOptions(PacketRequest{Op: OpRead, Options: []Option{{"windowsize", "0"}}}, -1)
+	assert.Error(t, err)
+}
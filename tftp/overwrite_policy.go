@@ -0,0 +1,231 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// OverwritePolicy decides what happens to a write request for a file that
+// already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteAllow replaces the file with the upload.
+	OverwriteAllow OverwritePolicy = iota
+	// OverwriteDeny refuses the request with ErrExists when the file exists
+	// as it arrives. A file created by another transfer meanwhile is replaced.
+	OverwriteDeny
+	// OverwriteCreateOnly refuses the request like OverwriteDeny, and also
+	// fails the upload with ErrExists if the file was created by another
+	// transfer before it completes, so a file is only ever written once.
+	OverwriteCreateOnly
+	// OverwriteKeepVersions replaces the file with the upload, but first keeps
+	// a copy of the previous contents as "<name>.1", "<name>.2" and so on,
+	// using the lowest number not taken yet.
+	OverwriteKeepVersions
+)
+
+var overwritePolicyNames = []string{
+	OverwriteAllow:        "allow",
+	OverwriteDeny:         "deny",
+	OverwriteCreateOnly:   "create-only",
+	OverwriteKeepVersions: "versions",
+}
+
+// ParseOverwritePolicy parses a policy named "allow", "deny", "create-only" or "versions".
+func ParseOverwritePolicy(value string) (OverwritePolicy, error) {
+	for policy, name := range overwritePolicyNames {
+		if value == name {
+			return OverwritePolicy(policy), nil
+		}
+	}
+
+	return OverwriteAllow, fmt.Errorf("invalid overwrite policy: %q", value)
+}
+
+func (p OverwritePolicy) String() string {
+	if p < 0 || int(p) >= len(overwritePolicyNames) {
+		return "OverwritePolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+
+	return overwritePolicyNames[p]
+}
+
+// OverwriteRule applies a policy to the files whose name matches Pattern, as
+// described for matchRequestPath.
+type OverwriteRule struct {
+	Pattern string
+	Policy  OverwritePolicy
+}
+
+// ParseOverwriteRule parses a rule written as "pattern=policy".
+func ParseOverwriteRule(value string) (OverwriteRule, error) {
+	pattern, name, found := strings.Cut(value, "=")
+	if !found || pattern == "" {
+		return OverwriteRule{}, fmt.Errorf("invalid overwrite rule: %q", value)
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return OverwriteRule{}, fmt.Errorf("invalid overwrite rule: %q: %w", value, err)
+	}
+
+	policy, err := ParseOverwritePolicy(name)
+	if err != nil {
+		return OverwriteRule{}, err
+	}
+
+	return OverwriteRule{Pattern: pattern, Policy: policy}, nil
+}
+
+// overwritePolicy returns the policy of the first rule matching filename, or
+// Overwrite when none does.
+func (s *TftpServer) overwritePolicy(filename string) OverwritePolicy {
+	for _, rule := range s.OverwriteRules {
+		if matchRequestPath(rule.Pattern, filename) {
+			return rule.Policy
+		}
+	}
+
+	return s.Overwrite
+}
+
+// createUpload starts an upload of filename according to its overwrite policy.
+func (s *TftpServer) createUpload(filename string) (Upload, error) {
+	policy := s.overwritePolicy(filename)
+
+	if policy == OverwriteDeny || policy == OverwriteCreateOnly {
+		exists, err := s.fileExists(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return nil, &fs.PathError{Op: "create", Path: filename, Err: fs.ErrExist}
+		}
+	}
+
+	upload, err := s.fileStorage.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch policy {
+	case OverwriteCreateOnly:
+		return &createOnlyUpload{Upload: upload, server: s, filename: filename}, nil
+	case OverwriteKeepVersions:
+		return &versionedUpload{Upload: upload, server: s, filename: filename}, nil
+	default:
+		return upload, nil
+	}
+}
+
+// fileExists reports whether filename exists in the storage. A file that
+// can't be opened for another reason than not existing, such as a directory
+// or a file the server may not read, is reported as an error so it isn't
+// mistaken for a missing one and replaced.
+func (s *TftpServer) fileExists(filename string) (bool, error) {
+	file, _, err := s.fileStorage.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	file.Close()
+	return true, nil
+}
+
+// createOnlyUpload is an upload that fails to commit if its file exists.
+type createOnlyUpload struct {
+	Upload
+	server   *TftpServer
+	filename string
+}
+
+func (u *createOnlyUpload) Commit() error {
+	u.server.commitMu.Lock()
+	defer u.server.commitMu.Unlock()
+
+	exists, err := u.server.fileExists(u.filename)
+	if err == nil && exists {
+		err = &fs.PathError{Op: "commit", Path: u.filename, Err: fs.ErrExist}
+	}
+
+	if err != nil {
+		u.Upload.Abort()
+		return err
+	}
+
+	return u.Upload.Commit()
+}
+
+// versionedUpload is an upload that copies the previous contents of its file
+// to a numbered version before replacing them.
+type versionedUpload struct {
+	Upload
+	server   *TftpServer
+	filename string
+}
+
+func (u *versionedUpload) Commit() error {
+	u.server.commitMu.Lock()
+	defer u.server.commitMu.Unlock()
+
+	if err := u.server.keepVersion(u.filename); err != nil {
+		u.Upload.Abort()
+		return err
+	}
+
+	return u.Upload.Commit()
+}
+
+// keepVersion copies the contents of filename, if it exists, to the lowest
+// numbered version not taken yet. It must be called with s.commitMu held.
+func (s *TftpServer) keepVersion(filename string) error {
+	file, _, err := s.fileStorage.Open(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var versionName string
+
+	for version := 1; ; version++ {
+		versionName = filename + "." + strconv.Itoa(version)
+
+		exists, err := s.fileExists(versionName)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			break
+		}
+	}
+
+	upload, err := s.fileStorage.Create(versionName)
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+
+	if _, err := io.Copy(upload, file); err != nil {
+		return err
+	}
+
+	if err := upload.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Kept previous contents of %s as %s \n", filename, versionName)
+	return nil
+}
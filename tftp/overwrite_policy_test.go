@@ -0,0 +1,137 @@
+package tftp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverwriteRule(t *testing.T) {
+	rule, err := ParseOverwriteRule("configs/*.cfg=create-only")
+	assert.NoError(t, err)
+	assert.Equal(t, OverwriteRule{Pattern: "configs/*.cfg", Policy: OverwriteCreateOnly}, rule)
+
+	for _, value := range []string{"", "*.cfg", "=deny", "*.cfg=replace", "[=deny"} {
+		_, err := ParseOverwriteRule(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestOverwriteRulesOverrideServerPolicy(t *testing.T) {
+	tftp_server := NewServer(0)
+	tftp_server.Overwrite = OverwriteDeny
+	tftp_server.OverwriteRules = []OverwriteRule{
+		{Pattern: "backups/*", Policy: OverwriteKeepVersions},
+		{Pattern: "*.log", Policy: OverwriteAllow},
+	}
+
+	assert.Equal(t, OverwriteKeepVersions, tftp_server.overwritePolicy("backups/switch.cfg"))
+	assert.Equal(t, OverwriteKeepVersions, tftp_server.overwritePolicy(`backups\switch.cfg`))
+	assert.Equal(t, OverwriteAllow, tftp_server.overwritePolicy("boot.log"))
+	assert.Equal(t, OverwriteDeny, tftp_server.overwritePolicy("backups/old/switch.cfg"))
+}
+
+// uploadWithPolicy uploads contents through the overwrite policy of the server.
+func uploadWithPolicy(tftp_server *TftpServer, filename string, contents string) (Upload, error) {
+	upload, err := tftp_server.createUpload(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	upload.Write([]byte(contents))
+	return upload, nil
+}
+
+func TestOverwriteDenyRefusesExistingFiles(t *testing.T) {
+	memory_storage := CreateEmptyMemoryStorage()
+	uploadToMemoryStorage(memory_storage, "golden.cfg", "golden")
+
+	tftp_server := NewServerWithStorage(0, NewBlockStorageAdapter(memory_storage))
+	tftp_server.Overwrite = OverwriteDeny
+
+	_, err := uploadWithPolicy(tftp_server, "golden.cfg", "")
+	assert.ErrorIs(t, err, fs.ErrExist)
+	assert.Equal(t, ErrExists, storageErrorCode(err))
+
+	upload, err := uploadWithPolicy(tftp_server, "new.cfg", "new")
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Commit())
+	assert.Equal(t, []byte("golden"), readMemoryStorage(t, memory_storage, "golden.cfg", 0, 512))
+}
+
+func TestOverwriteDenyRefusesFilesThatCannotBeOpened(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "golden.cfg"), []byte("golden"), 0644))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "golden.cfg"), filepath.Join(root, "golden.cfg")))
+
+	dir_storage, err := NewDirFileStorage(root)
+	assert.NoError(t, err)
+
+	for _, policy := range []OverwritePolicy{OverwriteDeny, OverwriteCreateOnly} {
+		tftp_server := NewServerWithStorage(0, dir_storage)
+		tftp_server.Overwrite = policy
+
+		// The link leads outside the root, so it can't be opened, but it exists.
+		_, err = uploadWithPolicy(tftp_server, "golden.cfg", "")
+		assert.ErrorIs(t, err, fs.ErrPermission, policy.String())
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "golden.cfg"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(outside, "golden.cfg"), target)
+}
+
+func TestOverwriteCreateOnlyFailsCommitOfFileCreatedMeanwhile(t *testing.T) {
+	memory_storage := CreateEmptyMemoryStorage()
+	tftp_server := NewServerWithStorage(0, NewBlockStorageAdapter(memory_storage))
+	tftp_server.Overwrite = OverwriteCreateOnly
+
+	first, err := uploadWithPolicy(tftp_server, "switch.cfg", "first")
+	assert.NoError(t, err)
+	second, err := uploadWithPolicy(tftp_server, "switch.cfg", "second")
+	assert.NoError(t, err)
+
+	assert.NoError(t, first.Commit())
+	assert.ErrorIs(t, second.Commit(), fs.ErrExist)
+	assert.NoError(t, second.Close())
+
+	assert.Equal(t, []byte("first"), readMemoryStorage(t, memory_storage, "switch.cfg", 0, 512))
+}
+
+func TestOverwriteKeepVersionsKeepsPreviousContents(t *testing.T) {
+	memory_storage := CreateEmptyMemoryStorage()
+	tftp_server := NewServerWithStorage(0, NewBlockStorageAdapter(memory_storage))
+	tftp_server.Overwrite = OverwriteKeepVersions
+
+	for _, contents := range []string{"v1", "v2", "v3"} {
+		upload, err := uploadWithPolicy(tftp_server, "switch.cfg", contents)
+		assert.NoError(t, err)
+		assert.NoError(t, upload.Commit())
+	}
+
+	assert.Equal(t, []byte("v3"), readMemoryStorage(t, memory_storage, "switch.cfg", 0, 512))
+	assert.Equal(t, []byte("v1"), readMemoryStorage(t, memory_storage, "switch.cfg.1", 0, 512))
+	assert.Equal(t, []byte("v2"), readMemoryStorage(t, memory_storage, "switch.cfg.2", 0, 512))
+	_, exists := memory_storage.GetFileMetadata("switch.cfg.3")
+	assert.False(t, exists)
+}
+
+func TestWriteOfExistingFileReportsErrExists(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	tftp_server.Overwrite = OverwriteDeny
+	uploadToMemoryStorage(memory_storage, "golden.cfg", "golden")
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "golden.cfg", Mode: "octet"})
+	assertReceivedError(t, conn, ErrExists)
+
+	assert.Equal(t, []byte("golden"), readMemoryStorage(t, memory_storage, "golden.cfg", 0, 512))
+}
@@ -0,0 +1,228 @@
+package tftp
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PreloadFilter selects the files loaded by PreloadDir and PreloadTar with
+// patterns in the syntax of path.Match. Patterns are matched against the
+// slash-separated path of a file, or only against its base name when they
+// contain no slash, so "*.bin" matches firmware images in any directory.
+type PreloadFilter struct {
+	// Include lists the patterns of the files to load. When empty every file
+	// is loaded.
+	Include []string
+	// Exclude lists the patterns of the files to skip, even if included.
+	Exclude []string
+}
+
+// PreloadSummary describes what PreloadDir or PreloadTar loaded.
+type PreloadSummary struct {
+	// Files is the number of files loaded.
+	Files int
+	// Bytes is the total size of the files loaded.
+	Bytes int64
+	// Skipped is the number of files left out by the filter.
+	Skipped int
+}
+
+func (s PreloadSummary) String() string {
+	return fmt.Sprintf("%d files (%d bytes) loaded, %d skipped", s.Files, s.Bytes, s.Skipped)
+}
+
+// Validate reports the first malformed pattern of the filter.
+func (f PreloadFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the file at the slash-separated path name is selected.
+func (f PreloadFilter) matches(name string) bool {
+	included := len(f.Include) == 0
+
+	for _, pattern := range f.Include {
+		if matchPreloadPattern(pattern, name) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, pattern := range f.Exclude {
+		if matchPreloadPattern(pattern, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchPreloadPattern(pattern string, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// PreloadDir stores the regular files under dir in storage, named by their
+// slash-separated path relative to dir. Symbolic links to files are followed,
+// links to directories are not. Files left out by the filter and special
+// files, such as named pipes, are never opened. Loading stops at the first
+// file that fails.
+func PreloadDir(storage FileStorage, dir string, filter PreloadFilter) (PreloadSummary, error) {
+	var summary PreloadSummary
+
+	if err := filter.Validate(); err != nil {
+		return summary, err
+	}
+
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		if !entry.Type().IsRegular() && entry.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		relative, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(relative)
+
+		if !filter.matches(name) {
+			summary.Skipped++
+			return nil
+		}
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(filePath)
+			if err != nil {
+				return err
+			}
+
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return preloadFile(storage, name, file, &summary)
+	})
+
+	return summary, err
+}
+
+// PreloadTar stores the regular files of a tar archive, optionally gzip
+// compressed, in storage, named by their path in the archive without a
+// leading "./" or "/". Loading stops at the first file that fails.
+func PreloadTar(storage FileStorage, r io.Reader, filter PreloadFilter) (PreloadSummary, error) {
+	var summary PreloadSummary
+
+	if err := filter.Validate(); err != nil {
+		return summary, err
+	}
+
+	buffered := bufio.NewReader(r)
+
+	// gzip streams start with the magic bytes 0x1f 0x8b.
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return summary, err
+		}
+		defer decompressed.Close()
+
+		r = decompressed
+	} else {
+		r = buffered
+	}
+
+	archive := tar.NewReader(r)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimLeft(path.Clean("/"+header.Name), "/")
+
+		if !filter.matches(name) {
+			summary.Skipped++
+			continue
+		}
+
+		if err := preloadFile(storage, name, archive, &summary); err != nil {
+			return summary, err
+		}
+	}
+}
+
+// preloadFile stores a file, counting it in summary.
+func preloadFile(storage FileStorage, name string, contents io.Reader, summary *PreloadSummary) error {
+	upload, err := storage.Create(name)
+	if err != nil {
+		return fmt.Errorf("preload %s: %w", name, err)
+	}
+	defer upload.Close()
+
+	size, err := io.Copy(upload, contents)
+	if err == nil {
+		err = upload.Commit()
+	}
+
+	if err != nil {
+		return fmt.Errorf("preload %s: %w", name, err)
+	}
+
+	summary.Files++
+	summary.Bytes += size
+	return nil
+}
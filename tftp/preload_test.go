@@ -0,0 +1,113 @@
+package tftp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreloadFilterMatches(t *testing.T) {
+	filter := PreloadFilter{Include: []string{"*.bin", "configs/*"}, Exclude: []string{"*-debug.bin"}}
+
+	assert.True(t, filter.matches("firmware.bin"))
+	assert.True(t, filter.matches("images/v2/firmware.bin"))
+	assert.True(t, filter.matches("configs/switch.cfg"))
+	assert.False(t, filter.matches("configs/old/switch.cfg"))
+	assert.False(t, filter.matches("images/firmware-debug.bin"))
+	assert.False(t, filter.matches("README"))
+
+	assert.True(t, PreloadFilter{}.matches("anything"))
+	assert.Error(t, PreloadFilter{Exclude: []string{"["}}.Validate())
+}
+
+func TestPreloadDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "pxelinux.cfg"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pxelinux.0"), []byte("bootloader"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pxelinux.cfg", "default"), []byte("menu"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skipped"), 0644))
+	assert.NoError(t, os.Symlink("pxelinux.0", filepath.Join(dir, "lpxelinux.0")))
+
+	memory_storage := CreateEmptyMemoryStorage()
+	summary, err := PreloadDir(NewBlockStorageAdapter(memory_storage), dir, PreloadFilter{Exclude: []string{"*.txt"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, PreloadSummary{Files: 3, Bytes: 24, Skipped: 1}, summary)
+	assert.Equal(t, []byte("bootloader"), readMemoryStorage(t, memory_storage, "pxelinux.0", 0, 512))
+	assert.Equal(t, []byte("bootloader"), readMemoryStorage(t, memory_storage, "lpxelinux.0", 0, 512))
+	assert.Equal(t, []byte("menu"), readMemoryStorage(t, memory_storage, "pxelinux.cfg/default", 0, 512))
+	_, exists := memory_storage.GetFileMetadata("notes.txt")
+	assert.False(t, exists)
+
+	_, err = PreloadDir(NewBlockStorageAdapter(memory_storage), filepath.Join(dir, "missing"), PreloadFilter{})
+	assert.Error(t, err)
+}
+
+func TestPreloadDirOpensOnlySelectedRegularFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "firmware.bin"), []byte("firmware"), 0644))
+	assert.NoError(t, syscall.Mkfifo(filepath.Join(dir, "pipe"), 0644))
+	assert.NoError(t, syscall.Mkfifo(filepath.Join(dir, "stream.bin"), 0644))
+	assert.NoError(t, os.Symlink("missing", filepath.Join(dir, "dangling")))
+
+	memory_storage := CreateEmptyMemoryStorage()
+	summary, err := PreloadDir(NewBlockStorageAdapter(memory_storage), dir, PreloadFilter{Include: []string{"*.bin"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, PreloadSummary{Files: 1, Bytes: 8, Skipped: 1}, summary)
+	_, exists := memory_storage.GetFileMetadata("stream.bin")
+	assert.False(t, exists)
+}
+
+// buildTar returns a tar archive holding a directory and the given files.
+func buildTar(t *testing.T, files map[string]string) []byte {
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+
+	assert.NoError(t, writer.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./boot/", Mode: 0755}))
+
+	for name, contents := range files {
+		assert.NoError(t, writer.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(contents))}))
+		_, err := writer.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, writer.Close())
+	return archive.Bytes()
+}
+
+func TestPreloadTar(t *testing.T) {
+	archive := buildTar(t, map[string]string{"./boot/kernel": "kernel", "/boot/initrd": "initrd", "notes.txt": "skipped"})
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write(archive)
+	gzipWriter.Close()
+
+	for _, input := range [][]byte{archive, compressed.Bytes()} {
+		memory_storage := CreateEmptyMemoryStorage()
+		summary, err := PreloadTar(NewBlockStorageAdapter(memory_storage), bytes.NewReader(input), PreloadFilter{Include: []string{"boot/*"}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, PreloadSummary{Files: 2, Bytes: 12, Skipped: 1}, summary)
+		assert.Equal(t, []byte("kernel"), readMemoryStorage(t, memory_storage, "boot/kernel", 0, 512))
+		assert.Equal(t, []byte("initrd"), readMemoryStorage(t, memory_storage, "boot/initrd", 0, 512))
+	}
+}
+
+func TestPreloadReportsStorageErrors(t *testing.T) {
+	memory_storage := CreateEmptyMemoryStorage()
+	memory_storage.MaxFileSize = 4
+
+	archive := buildTar(t, map[string]string{"boot/kernel": "kernel"})
+	_, err := PreloadTar(NewBlockStorageAdapter(memory_storage), bytes.NewReader(archive), PreloadFilter{})
+
+	assert.ErrorIs(t, err, ErrDiskFull)
+	assert.Contains(t, err.Error(), "boot/kernel")
+}
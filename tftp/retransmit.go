@@ -0,0 +1,106 @@
+package tftp
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	defaultTimeout    time.Duration = 3 * time.Second
+	defaultMaxRetries int           = 5
+	// Upper bound of the retransmission interval when backing off exponentially.
+	maxBackoffTimeout time.Duration = 60 * time.Second
+)
+
+// BackoffPolicy decides how the retransmission interval changes between retries.
+type BackoffPolicy int
+
+const (
+	// BackoffFixed retransmits at the same interval every time.
+	BackoffFixed BackoffPolicy = iota
+	// BackoffExponential doubles the interval after every retransmission.
+	BackoffExponential
+)
+
+// retransmitTimer tracks how long a transfer waits for the peer before the
+// last packet is sent again, and how many times that may happen. The deadline
+// only moves when the peer makes progress or a retransmission is made, so
+// packets ignored in between don't postpone the retransmission.
+type retransmitTimer struct {
+	timeout    time.Duration
+	backoff    BackoffPolicy
+	maxRetries int
+	retries    int
+	expires    time.Time
+}
+
+func (s *TftpServer) newRetransmitTimer(options transferOptions) *retransmitTimer {
+	timeout := options.timeout
+	if timeout <= 0 {
+		timeout = s.Timeout
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	maxRetries := s.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+
+	timer := &retransmitTimer{
+		timeout:    timeout,
+		backoff:    s.Backoff,
+		maxRetries: maxRetries,
+	}
+	timer.restart()
+
+	return timer
+}
+
+// interval returns how long to wait for the peer before the next retransmission.
+func (t *retransmitTimer) interval() time.Duration {
+	if t.backoff != BackoffExponential {
+		return t.timeout
+	}
+
+	interval := t.timeout
+	for i := 0; i < t.retries && interval < maxBackoffTimeout; i++ {
+		interval *= 2
+	}
+
+	if interval > maxBackoffTimeout {
+		interval = maxBackoffTimeout
+	}
+
+	return interval
+}
+
+// deadline returns when the next retransmission is due.
+func (t *retransmitTimer) deadline() time.Time {
+	return t.expires
+}
+
+// restart starts waiting a full interval from now.
+func (t *retransmitTimer) restart() {
+	t.expires = time.Now().Add(t.interval())
+}
+
+// expire records a timeout and reports whether the transfer may retransmit.
+// The wait for the retransmitted packet starts right away.
+func (t *retransmitTimer) expire() bool {
+	t.retries++
+	t.restart()
+	return t.retries <= t.maxRetries
+}
+
+// reset is called whenever the peer makes progress.
+func (t *retransmitTimer) reset() {
+	t.retries = 0
+	t.restart()
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,67 @@
+package tftp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetransmitTimerFixedBackoff(t *testing.T) {
+	server := TftpServer{Timeout: time.Second, MaxRetries: 2, Backoff: BackoffFixed}
+	timer := server.newRetransmitTimer(defaultTransferOptions())
+
+	assert.Equal(t, time.Second, timer.interval())
+	assert.True(t, timer.expire())
+	assert.Equal(t, time.Second, timer.interval())
+	assert.True(t, timer.expire())
+	assert.False(t, timer.expire())
+}
+
+func TestRetransmitTimerExponentialBackoff(t *testing.T) {
+	server := TftpServer{Timeout: 20 * time.Second, MaxRetries: 5, Backoff: BackoffExponential}
+	timer := server.newRetransmitTimer(defaultTransferOptions())
+
+	assert.Equal(t, 20*time.Second, timer.interval())
+	timer.expire()
+	assert.Equal(t, 40*time.Second, timer.interval())
+	timer.expire()
+	assert.Equal(t, maxBackoffTimeout, timer.interval())
+
+	timer.reset()
+	assert.Equal(t, 20*time.Second, timer.interval())
+}
+
+func TestRetransmitTimerDeadline(t *testing.T) {
+	server := TftpServer{Timeout: time.Second}
+	timer := server.newRetransmitTimer(defaultTransferOptions())
+
+	deadline := timer.deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, deadline, timer.deadline(), "the deadline only moves on progress or retransmission")
+
+	timer.reset()
+	assert.True(t, timer.deadline().After(deadline))
+
+	deadline = timer.deadline()
+	time.Sleep(10 * time.Millisecond)
+	timer.expire()
+	assert.True(t, timer.deadline().After(deadline))
+}
+
+func TestRetransmitTimerDefaults(t *testing.T) {
+	timer := (&TftpServer{}).newRetransmitTimer(defaultTransferOptions())
+
+	assert.Equal(t, defaultTimeout, timer.interval())
+	assert.Equal(t, defaultMaxRetries, timer.maxRetries)
+}
+
+func TestRetransmitTimerNegotiatedTimeout(t *testing.T) {
+	server := TftpServer{Timeout: time.Second}
+	options := defaultTransferOptions()
+	options.timeout = 5 * time.Second
+
+	assert.Equal(t, 5*time.Second, server.newRetransmitTimer(options).interval())
+}
@@ -1,222 +1,579 @@
 package tftp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
 	"net"
 	"strconv"
-)
-
-const (
-	min_data_port int = 49152
-	max_data_port int = 65535
+	"sync"
+	"time"
 )
 
 type TftpServer struct {
-	Port        int
-	fileStorage FileStorage
-	uploads     map[string]string
-	downloads   map[string]DownloadMetadata
-	quit        chan bool
+	// Addr is the UDP address to listen on, in the form "host:port". When empty
+	// the server listens on Port on all interfaces.
+	Addr string
+	Port int
+	// Network is "udp4" or "udp6" to serve a single IP version. When empty
+	// the server listens on both IPv4 and IPv6 where the address allows it.
+	Network string
+	// ListenPacket opens the listening socket and the sockets of transfers.
+	// It defaults to net.ListenPacket and may be replaced to use another transport.
+	ListenPacket func(network string, address string) (net.PacketConn, error)
+	// MaxBlockSize caps the block size clients may negotiate with the blksize option.
+	MaxBlockSize int
+	// Timeout is how long a transfer waits for the peer before retransmitting.
+	Timeout time.Duration
+	// MaxRetries is how many retransmissions are made before a transfer is abandoned.
+	MaxRetries int
+	// Backoff decides how Timeout grows between consecutive retransmissions.
+	Backoff BackoffPolicy
+	// MaxUploadSize is the largest file accepted by a WRQ, in bytes. Zero means no limit.
+	MaxUploadSize int
+	// MaxWindowSize caps the number of blocks clients may negotiate with the windowsize option.
+	MaxWindowSize int
+	// RolloverToOne makes the block number following 65535 in transfers of
+	// large files 1 instead of 0.
+	RolloverToOne bool
+	// TransferPorts limits the local ports of transfer sockets, for firewalls
+	// that only let a narrow range through. By default the operating system
+	// assigns a free ephemeral port to every transfer.
+	TransferPorts PortRange
+	// Overwrite decides what happens to a write request for a file that
+	// already exists. By default the file is replaced.
+	Overwrite OverwritePolicy
+	// OverwriteRules override Overwrite for the files they match. The first
+	// matching rule applies.
+	OverwriteRules []OverwriteRule
+	// Access decides which requests are accepted. By default files can be
+	// both read and written.
+	Access AccessMode
+	// AccessRules override Access for the files they match. The first
+	// matching rule applies.
+	AccessRules []AccessRule
+	// Clients decides which clients may send requests. ReadClients and
+	// WriteClients further restrict the clients of read and write requests.
+	// By default requests are accepted from any address.
+	Clients      ClientACL
+	ReadClients  ClientACL
+	WriteClients ClientACL
+	// DropRejectedClients ignores the requests of rejected clients rather
+	// than answering them with an access violation.
+	DropRejectedClients bool
+
+	mtuBlockSize int
+	fileStorage  FileStorage
+	ports        portPool
+	// commitMu serialises the commits that depend on whether their file exists.
+	commitMu sync.Mutex
+
+	mu         sync.Mutex
+	listener   net.PacketConn
+	inShutdown bool
+	transfers  transferRegistry
 }
 
+// NewServer returns a server on port keeping its files in memory.
 func NewServer(port int) *TftpServer {
+	return NewServerWithStorage(port, NewBlockStorageAdapter(CreateEmptyMemoryStorage()))
+}
+
+// NewServerWithStorage returns a server on port serving the files of storage,
+// such as a DirFileStorage.
+func NewServerWithStorage(port int, storage FileStorage) *TftpServer {
 	return &TftpServer{
-		Port:        port,
-		uploads:     map[string]string{},
-		downloads:   map[string]DownloadMetadata{},
-		fileStorage: CreateEmptyMemoryStorage(),
+		Port:          port,
+		MaxBlockSize:  maxBlockSize,
+		Timeout:       defaultTimeout,
+		MaxRetries:    defaultMaxRetries,
+		Backoff:       BackoffFixed,
+		MaxWindowSize: defaultMaxWindowSize,
+		fileStorage:   storage,
 	}
 }
 
-func (s *TftpServer) Start() error {
-	fmt.Printf("Starting TFTP server on port %d \n", s.Port)
+// ErrServerClosed is returned by Serve once the server has been shut down or closed.
+var ErrServerClosed = errors.New("tftp: server closed")
 
-	udpAddress, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(s.Port))
+// Start serves requests until the server is shut down or closed.
+func (s *TftpServer) Start() error {
+	err := s.ListenAndServe(context.Background())
 
-	if err != nil {
-		fmt.Println("Error resolving server address.")
-		return err
+	if err == ErrServerClosed {
+		return nil
 	}
 
-	connection, err := net.ListenUDP("udp4", udpAddress)
+	return err
+}
+
+// ListenAndServe listens on the server's address and handles requests
+// arriving there, see Serve.
+func (s *TftpServer) ListenAndServe(ctx context.Context) error {
+	address := s.listenAddress()
+
+	fmt.Printf("Starting TFTP server on %s \n", address)
+
+	connection, err := s.listenPacket(s.listenNetwork(), address)
 
 	if err != nil {
-		fmt.Printf("Error listening on address: %s \n", udpAddress)
+		fmt.Printf("Error listening on address: %s \n", address)
 		return err
 	}
 
+	return s.Serve(ctx, connection)
+}
+
+// Serve handles requests arriving on connection until Shutdown or Close is
+// called, or ctx is cancelled. Cancelling ctx closes the server right away,
+// like Close. Transfer sockets are opened on the same address family and
+// interface as connection. Serve closes connection before returning.
+func (s *TftpServer) Serve(ctx context.Context, connection net.PacketConn) error {
 	defer connection.Close()
 
-	s.quit = make(chan bool)
+	if localAddr, ok := connection.LocalAddr().(*net.UDPAddr); ok {
+		s.mtuBlockSize = mtuBlockSize(localAddr.IP)
+	}
 
-	buffer := make([]byte, 512)
+	s.mu.Lock()
+	if s.inShutdown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listener = connection
+	s.mu.Unlock()
 
-	for {
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
 		select {
-		case <-s.quit:
-			fmt.Printf("Terminating server...")
-			return nil
-		default:
-			s.acceptReqest(connection, buffer)
+		case <-ctx.Done():
+			s.Close()
+		case <-stopped:
+		}
+	}()
+
+	buffer := make([]byte, MaxPacketSize)
+
+	for {
+		err := s.acceptReqest(connection, buffer)
+
+		if err != nil {
+			if !s.shuttingDown() {
+				return err
+			}
+
+			fmt.Println("Terminating server...")
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrServerClosed
 		}
 	}
 }
 
-func (s *TftpServer) Terminate() {
-	s.quit <- true
+// Shutdown stops accepting new requests and waits for the transfers in
+// progress to finish. If ctx expires first, the remaining transfers are
+// closed and the context's error is returned.
+func (s *TftpServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	s.closeListener()
+	s.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.transfers.wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
 }
 
-func (s *TftpServer) connectionInitializationHandler(connection *net.UDPConn, quit chan bool) {
-	buffer := make([]byte, 512)
+// Close stops the server immediately, abandoning the transfers in progress.
+func (s *TftpServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	for {
-		select {
-		case <-quit:
-			fmt.Println("Exiting connectionInitializationHandler...")
-			return
-		default:
-			s.acceptReqest(connection, buffer)
-		}
+	s.inShutdown = true
+	err := s.closeListener()
+	s.transfers.closeAll()
+
+	return err
+}
+
+// closeListener must be called with s.mu held.
+func (s *TftpServer) closeListener() error {
+	if s.listener == nil {
+		return nil
 	}
+
+	err := s.listener.Close()
+	s.listener = nil
+	return err
 }
 
-func (s *TftpServer) acceptReqest(connection *net.UDPConn, buffer []byte) {
+func (s *TftpServer) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inShutdown
+}
+
+// Transfers returns the transfers in progress, ordered by ID.
+func (s *TftpServer) Transfers() []*Transfer {
+	return s.transfers.list()
+}
+
+// Transfer looks up a transfer in progress by its ID.
+func (s *TftpServer) Transfer(id uint64) (*Transfer, bool) {
+	return s.transfers.get(id)
+}
+
+// CancelTransfer aborts the transfer with the given ID and reports whether it
+// was in progress.
+func (s *TftpServer) CancelTransfer(id uint64) bool {
+	transfer, ok := s.transfers.get(id)
+	if ok {
+		transfer.Cancel()
+	}
 
-	_, addr, err := connection.ReadFromUDP(buffer)
+	return ok
+}
+
+// runTransfer registers a transfer and runs its handler in its own goroutine
+// until it returns. Once the server is shutting down no new transfers are
+// started and false is returned.
+func (s *TftpServer) runTransfer(transfer *Transfer, handler func(*Transfer)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown {
+		return false
+	}
+
+	s.transfers.add(transfer)
+
+	go func() {
+		defer s.transfers.remove(transfer)
+		defer transfer.conn.Close()
+
+		handler(transfer)
+	}()
+
+	return true
+}
+
+func (s *TftpServer) acceptReqest(connection net.PacketConn, buffer []byte) error {
+
+	n, addr, err := connection.ReadFrom(buffer)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	op, _ := PeekOp(buffer)
+	packet := buffer[:n]
+	op, _ := PeekOp(packet)
+
+	// Clients are checked before anything else, the storage included.
+	if (op == OpRead || op == OpWrite) && !s.clientAllowed(addr, op) {
+		fmt.Printf("Rejected %s request from client %s \n", op, addr)
+
+		if !s.DropRejectedClients {
+			s.sendError(connection, addr, ErrAccessViolation, "Access denied.")
+		}
+
+		return nil
+	}
 
 	switch op {
 	case OpWrite:
 		var requestPacket PacketRequest
-		requestPacket.UnmarshalBinary(buffer)
+		if err := requestPacket.UnmarshalBinary(packet); err != nil {
+			fmt.Printf("Malformed Write request from client %s: %s \n", addr, err)
+			s.sendError(connection, addr, ErrIllegal, "Malformed request.")
+			break
+		}
 
 		fmt.Printf("Received Write request for file: %s with mode: %s\n", requestPacket.Filename, requestPacket.Mode)
 
-		data_port := s.selectRandomPort()
-		data_port_str := strconv.Itoa(data_port)
+		if !s.accessMode(requestPacket.Filename).allows(OpWrite) {
+			fmt.Printf("Write access to file %s denied \n", requestPacket.Filename)
+			s.sendError(connection, addr, ErrAccessViolation, storageErrorMessage(ErrAccessViolation, requestPacket.Filename))
+			break
+		}
 
-		fmt.Printf("Will use data port: %s \n", data_port_str)
+		if !isSupportedMode(requestPacket.Mode) {
+			s.sendError(connection, addr, ErrIllegal, "Only octet and netascii modes are supported")
+			break
+		}
 
-		data_udp_address, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+data_port_str)
-		s.uploads[data_port_str] = requestPacket.Filename
+		options, ackedOptions, err := s.negotiateOptions(requestPacket, -1)
 
 		if err != nil {
-			fmt.Printf("Error when resolving address for data port: %s. \n", data_port_str)
-			s.sendError(connection, addr, ErrNotDefined, "Unknown error occurred.")
+			fmt.Printf("Option negotiation failed: %s \n", err)
+			s.sendError(connection, addr, negotiationErrorCode(err), err.Error())
+			break
+		}
+
+		if err := s.checkUploadSize(requestPacket.Filename, options.transferSize); err != nil {
+			fmt.Printf("Upload of file %s rejected: %s \n", requestPacket.Filename, err)
+			s.sendStorageError(connection, addr, err, requestPacket.Filename)
 			break
 		}
 
-		data_connection, err := net.DialUDP("udp4", data_udp_address, addr)
+		upload, err := s.createUpload(requestPacket.Filename)
 
 		if err != nil {
-			fmt.Printf("Error when opening data connection on port: %s. \n", data_port_str)
+			fmt.Printf("Error when creating file %s: %s \n", requestPacket.Filename, err)
+			s.sendStorageError(connection, addr, err, requestPacket.Filename)
+			break
+		}
+
+		data_connection, err := s.openTransfer(connection, addr)
+
+		if err != nil {
+			fmt.Printf("Error when opening data connection: %s \n", err)
 			s.sendError(connection, addr, ErrNotDefined, "Unknown error occurred.")
+			upload.Close()
 			break
 		}
 
-		go s.dataWriteHandler(data_connection, data_port_str)
+		transfer := &Transfer{
+			Op:           OpWrite,
+			Filename:     requestPacket.Filename,
+			Peer:         addr,
+			Started:      time.Now(),
+			conn:         data_connection,
+			upload:       upload,
+			options:      options,
+			ackedOptions: ackedOptions,
+		}
 
-		s.sendAck(data_connection, 0)
+		if !s.runTransfer(transfer, s.dataWriteHandler) {
+			data_connection.Close()
+			upload.Close()
+		}
 
 	case OpRead:
 		var requestPacket PacketRequest
-		requestPacket.UnmarshalBinary(buffer)
+		if err := requestPacket.UnmarshalBinary(packet); err != nil {
+			fmt.Printf("Malformed Read request from client %s: %s \n", addr, err)
+			s.sendError(connection, addr, ErrIllegal, "Malformed request.")
+			break
+		}
 
 		fmt.Printf("Received Read request for file: %s with mode: %s\n", requestPacket.Filename, requestPacket.Mode)
 
-		if requestPacket.Mode != "octet" {
-			s.sendError(connection, addr, ErrIllegal, "Only octet mode is supported")
+		if !s.accessMode(requestPacket.Filename).allows(OpRead) {
+			fmt.Printf("Read access to file %s denied \n", requestPacket.Filename)
+			s.sendError(connection, addr, ErrAccessViolation, storageErrorMessage(ErrAccessViolation, requestPacket.Filename))
 			break
 		}
 
-		if _, exists := s.fileStorage.GetFileMetadata(requestPacket.Filename); !exists {
-			s.sendError(connection, addr, ErrFileNotFound, fmt.Sprintf("File with name '%s' does not exist.", requestPacket.Filename))
+		if !isSupportedMode(requestPacket.Mode) {
+			s.sendError(connection, addr, ErrIllegal, "Only octet and netascii modes are supported")
 			break
 		}
 
-		data_port := s.selectRandomPort()
-		data_port_str := strconv.Itoa(data_port)
-
-		fmt.Printf("Will use data port: %s \n", data_port_str)
-
-		data_udp_address, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+data_port_str)
+		file, size, err := s.fileStorage.Open(requestPacket.Filename)
 
 		if err != nil {
-			fmt.Printf("Error when resolving address for data port: %s. \n", data_port_str)
-			s.sendError(connection, addr, ErrNotDefined, "Unknown error occurred.")
+			fmt.Printf("Error when opening file %s: %s \n", requestPacket.Filename, err)
+			s.sendStorageError(connection, addr, err, requestPacket.Filename)
 			break
 		}
 
-		s.downloads[data_port_str] = DownloadMetadata{
-			Filename:     requestPacket.Filename,
-			LastBlockNum: 0,
+		options, ackedOptions, err := s.negotiateOptions(requestPacket, size)
+
+		if err != nil {
+			fmt.Printf("Option negotiation failed: %s \n", err)
+			s.sendError(connection, addr, negotiationErrorCode(err), err.Error())
+			file.Close()
+			break
 		}
 
-		data_connection, err := net.DialUDP("udp4", data_udp_address, addr)
+		data_connection, err := s.openTransfer(connection, addr)
 
 		if err != nil {
-			fmt.Printf("Error when opening data connection on port: %s. \n", data_port_str)
+			fmt.Printf("Error when opening data connection: %s \n", err)
 			s.sendError(connection, addr, ErrNotDefined, "Unknown error occurred.")
+			file.Close()
 			break
 		}
 
-		go s.dataReadHandler(data_connection, data_port_str)
+		transfer := &Transfer{
+			Op:           OpRead,
+			Filename:     requestPacket.Filename,
+			Peer:         addr,
+			Started:      time.Now(),
+			conn:         data_connection,
+			file:         file,
+			options:      options,
+			ackedOptions: ackedOptions,
+		}
 
-		s.readAndSendFile(data_connection, requestPacket.Filename, 0)
+		if !s.runTransfer(transfer, s.dataReadHandler) {
+			data_connection.Close()
+			file.Close()
+		}
 	case OpAck:
 		fmt.Printf("Received ACK")
 	default:
 		fmt.Println("Unhandled operation")
 	}
 
+	return nil
 }
 
-func (s *TftpServer) dataWriteHandler(connection *net.UDPConn, port string) {
+// openTransfer opens the socket of a new transfer with peer, on a port
+// assigned by the operating system or taken from TransferPorts.
+func (s *TftpServer) openTransfer(listener net.PacketConn, peer net.Addr) (*transferConn, error) {
+	data_socket, release, err := s.ports.listen(s.TransferPorts, func(port int) (net.PacketConn, error) {
+		return s.listenTransfer(listener, peer, port)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	data_connection := &transferConn{PacketConn: data_socket, peer: peer, release: release}
+
+	fmt.Printf("Will use data port: %s \n", data_connection.port())
+
+	return data_connection, nil
+}
+
+func (s *TftpServer) dataWriteHandler(transfer *Transfer) {
+	connection, options, ackedOptions := transfer.conn, transfer.options, transfer.ackedOptions
+	filename := transfer.Filename
+	port := connection.port()
+
+	// Unless the upload completes it is aborted, the stored file is left as it was.
+	upload := transfer.upload
+	defer upload.Close()
+
+	packetSize := options.blockSize + dataHeaderSize
+	timer := s.newRetransmitTimer(options)
+	is_complete := false
+	received := 0
+	var blockNum uint64
+
+	var writer io.Writer = upload
+	var decoder *netasciiWriter
+	if options.netascii {
+		decoder = newNetasciiWriter(upload)
+		writer = decoder
+	}
+
+	var lastPacket []byte
+	if len(ackedOptions) > 0 {
+		lastPacket = s.sendOAck(connection, ackedOptions)
+	} else {
+		lastPacket = s.sendAck(connection, 0)
+	}
+
+	// Large enough for a full DATA packet as well as an ERROR packet with a long message.
+	bufferSize := packetSize
+	if bufferSize < MaxPacketSize {
+		bufferSize = MaxPacketSize
+	}
+
+	buffer := make([]byte, bufferSize)
+
 	for {
-		buffer := make([]byte, 516)
-		n, _, err := connection.ReadFromUDP(buffer)
-		s.logErrorIfExists(err)
+		connection.SetReadDeadline(timer.deadline())
+		n, err := connection.readFromPeer(buffer)
+
+		if err != nil {
+			if !isTimeout(err) {
+				s.logErrorIfExists(err)
+				return
+			}
 
-		op, _ := PeekOp(buffer)
+			// Once the upload is complete the final ACK is only resent on request,
+			// so a quiet peer means it has been received.
+			if is_complete {
+				return
+			}
+
+			if !timer.expire() {
+				fmt.Printf("Upload on port %s timed out \n", port)
+				s.abortTransfer(connection, ErrNotDefined, "Transfer timed out.")
+				return
+			}
+
+			fmt.Printf("Retransmitting last packet on port %s \n", port)
+			s.resend(connection, lastPacket)
+			continue
+		}
+
+		op, _ := PeekOp(buffer[:n])
 
 		switch op {
 		case OpData:
 			var dataPacket PacketData
-			dataPacket.UnmarshalBinary(buffer)
+			dataPacket.UnmarshalBinary(buffer[:n])
 
 			fmt.Printf("Received Data request for with BlockNum: %d on port %s\n", dataPacket.BlockNum, port)
 
-			if dataPacket.BlockNum == 1 {
-				s.fileStorage.StartNewUpload(s.uploads[port])
+			if blockNum > 0 && dataPacket.BlockNum == s.wireBlockNum(blockNum) {
+				// The ACK got lost and the client repeats the block. It is acknowledged
+				// again, but written only once.
+				s.resend(connection, lastPacket)
+				continue
 			}
 
-			s.fileStorage.AppendData(s.uploads[port], int(dataPacket.BlockNum), dataPacket.Data[0:n-4])
+			if is_complete || dataPacket.BlockNum != s.wireBlockNum(blockNum+1) {
+				fmt.Printf("Rejecting out-of-order block %d on port %s \n", dataPacket.BlockNum, port)
+				continue
+			}
 
-			is_complete := n < 516
-			if is_complete {
-				s.fileStorage.CompleteUpload(s.uploads[port])
-				delete(s.uploads, port)
-				is_complete = true
+			timer.reset()
+			blockNum++
+
+			received += len(dataPacket.Data)
+			if s.MaxUploadSize > 0 && received > s.MaxUploadSize {
+				fmt.Printf("Upload on port %s exceeds the upload limit \n", port)
+				s.abortTransfer(connection, ErrDiskFull, fmt.Sprintf("Files are limited to %d bytes.", s.MaxUploadSize))
+				return
 			}
 
-			s.sendAck(connection, dataPacket.BlockNum)
+			if _, err := writer.Write(dataPacket.Data); err != nil {
+				s.abortStorageError(connection, err, filename)
+				return
+			}
+			transfer.progress(len(dataPacket.Data))
 
+			is_complete = n < packetSize
 			if is_complete {
-				defer connection.Close()
+				if err := s.completeUpload(upload, decoder); err != nil {
+					s.abortStorageError(connection, err, filename)
+					return
+				}
 			}
 
+			lastPacket = s.sendAck(connection, dataPacket.BlockNum)
+
 		case OpAck:
 			var ackPacket PacketAck
-			ackPacket.UnmarshalBinary(buffer)
+			ackPacket.UnmarshalBinary(buffer[:n])
 
 			fmt.Printf("Received ACK\n")
 
+		case OpError:
+			s.logPeerError(buffer[:n], port)
+			return
+
 		default:
 			fmt.Printf("DataHandler Unhandled operation: %s \n", op)
 		}
@@ -224,23 +581,124 @@ func (s *TftpServer) dataWriteHandler(connection *net.UDPConn, port string) {
 	}
 }
 
-func (s *TftpServer) dataReadHandler(connection *net.UDPConn, port string) {
-	buffer := make([]byte, 512)
+func (s *TftpServer) dataReadHandler(transfer *Transfer) {
+	connection, options, ackedOptions := transfer.conn, transfer.options, transfer.ackedOptions
+	filename := transfer.Filename
+	port := connection.port()
+	timer := s.newRetransmitTimer(options)
+
+	defer transfer.file.Close()
+
+	var reader io.Reader = transfer.file
+	if options.netascii {
+		reader = newNetasciiReader(reader)
+	}
+
+	// DATA packets sent but not acknowledged yet, the first one carries block acked+1.
+	var window [][]byte
+	var acked uint64
+	is_last_block_read := false
+	var err error
+
+	// With an OACK the first window is sent once the client acknowledges it with ACK 0.
+	var oackPacket []byte
+	if len(ackedOptions) > 0 {
+		oackPacket = s.sendOAck(connection, ackedOptions)
+	} else {
+		window, is_last_block_read, err = s.fillWindow(connection, reader, acked, window, options)
+		if err != nil {
+			s.abortStorageError(connection, err, filename)
+			return
+		}
+	}
+
+	buffer := make([]byte, MaxPacketSize)
 
 	for {
-		_, _, err := connection.ReadFromUDP(buffer)
-		s.logErrorIfExists(err)
+		connection.SetReadDeadline(timer.deadline())
+		n, err := connection.readFromPeer(buffer)
 
-		op, _ := PeekOp(buffer)
+		if err != nil {
+			if !isTimeout(err) {
+				s.logErrorIfExists(err)
+				return
+			}
+
+			if !timer.expire() {
+				fmt.Printf("Download on port %s timed out \n", port)
+				s.abortTransfer(connection, ErrNotDefined, "Transfer timed out.")
+				return
+			}
+
+			fmt.Printf("Retransmitting unacknowledged packets on port %s \n", port)
+			if oackPacket != nil {
+				s.resend(connection, oackPacket)
+			} else {
+				s.resendWindow(connection, window)
+			}
+			continue
+		}
+
+		op, _ := PeekOp(buffer[:n])
 
 		switch op {
 		case OpAck:
 			var ackPacket PacketAck
-			ackPacket.UnmarshalBinary(buffer)
+			ackPacket.UnmarshalBinary(buffer[:n])
 
 			fmt.Printf("Received ACK for with BlockNum: %d on port %s\n", ackPacket.BlockNum, port)
 
-			s.readAndSendFile(connection, s.downloads[port].Filename, ackPacket.BlockNum)
+			if oackPacket != nil {
+				if ackPacket.BlockNum == 0 {
+					timer.reset()
+					oackPacket = nil
+					window, is_last_block_read, err = s.fillWindow(connection, reader, acked, window, options)
+					if err != nil {
+						s.abortStorageError(connection, err, filename)
+						return
+					}
+				}
+				continue
+			}
+
+			// The ACK is cumulative, it covers every block up to the acknowledged one.
+			blockNum, inWindow := s.logicalBlockNum(ackPacket.BlockNum, acked+1, acked+uint64(len(window)))
+
+			if !inWindow {
+				// Duplicate and stale ACKs are ignored, answering them would duplicate
+				// every following DATA packet (the Sorcerer's Apprentice Syndrome).
+				// Unacknowledged blocks are sent again when the timer expires, which
+				// ignored packets don't postpone.
+				continue
+			}
+
+			timer.reset()
+			for _, packet := range window[:blockNum-acked] {
+				transfer.progress(len(packet) - dataHeaderSize)
+			}
+			window = window[blockNum-acked:]
+			acked = blockNum
+
+			if len(window) == 0 && is_last_block_read {
+				fmt.Printf("Completed download of file: %s \n", filename)
+				return
+			}
+
+			// Some blocks of the window got lost, send them again before moving on.
+			s.resendWindow(connection, window)
+
+			if !is_last_block_read {
+				window, is_last_block_read, err = s.fillWindow(connection, reader, acked, window, options)
+				if err != nil {
+					s.abortStorageError(connection, err, filename)
+					return
+				}
+			}
+
+		case OpError:
+			s.logPeerError(buffer[:n], port)
+			return
+
 		default:
 			fmt.Printf("DataHandler Unhandled operation: %s \n", op)
 		}
@@ -248,25 +706,57 @@ func (s *TftpServer) dataReadHandler(connection *net.UDPConn, port string) {
 	}
 }
 
-func (s *TftpServer) readAndSendFile(connection *net.UDPConn, filename string, prevBlockNum uint16) {
-	bytesStart := int(prevBlockNum * 512)
-	bytesEnd := bytesStart + 512
+// fillWindow reads and sends blocks until the window holds windowSize
+// unacknowledged blocks. It returns the window and whether the final block of
+// the file has been read.
+func (s *TftpServer) fillWindow(connection *transferConn, reader io.Reader, acked uint64, window [][]byte, options transferOptions) ([][]byte, bool, error) {
+	for len(window) < options.windowSize {
+		packet, is_last_block, err := s.readAndSendFile(connection, reader, acked+uint64(len(window))+1, options.blockSize)
+		if err != nil {
+			return window, false, err
+		}
+
+		window = append(window, packet)
+
+		if is_last_block {
+			return window, true, nil
+		}
+	}
+
+	return window, false, nil
+}
+
+func (s *TftpServer) resendWindow(connection *transferConn, window [][]byte) {
+	for _, packet := range window {
+		s.resend(connection, packet)
+	}
+}
 
-	fileBlock := s.fileStorage.ReadFileBytes(filename, bytesStart, bytesEnd)
+// readAndSendFile reads the next block of a file and sends it as the given
+// block. It returns the sent packet and whether it is the final, short block
+// of the file.
+func (s *TftpServer) readAndSendFile(connection *transferConn, reader io.Reader, blockNum uint64, blockSize int) ([]byte, bool, error) {
+	fileBlock := make([]byte, blockSize)
+	n, err := io.ReadFull(reader, fileBlock)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	fileBlock = fileBlock[:n]
 
 	dataPacket := PacketData{
 		Op:       OpData,
-		BlockNum: uint16(prevBlockNum + 1),
+		BlockNum: s.wireBlockNum(blockNum),
 		Data:     fileBlock,
 	}
 
 	data_data, err := dataPacket.MarshalBinary()
 	s.logErrorIfExists(err)
 
-	connection.Write(data_data)
+	s.resend(connection, data_data)
+	return data_data, len(fileBlock) < blockSize, nil
 }
 
-func (s *TftpServer) sendAck(connection *net.UDPConn, blockNum uint16) {
+func (s *TftpServer) sendAck(connection *transferConn, blockNum uint16) []byte {
 	ackPacket := PacketAck{
 		Op:       OpAck,
 		BlockNum: blockNum,
@@ -283,9 +773,42 @@ func (s *TftpServer) sendAck(connection *net.UDPConn, blockNum uint16) {
 	if err != nil {
 		fmt.Printf("ACK write error: %s \n", err)
 	}
+
+	return ack_data
+}
+
+func (s *TftpServer) sendOAck(connection *transferConn, options []Option) []byte {
+	oackPacket := PacketOAck{
+		Op:      OpOAck,
+		Options: options,
+	}
+
+	oack_data, err := oackPacket.MarshalBinary()
+
+	if err != nil {
+		fmt.Printf("OACK marshalling error: %s \n", err)
+	}
+
+	_, err = connection.Write(oack_data)
+
+	if err != nil {
+		fmt.Printf("OACK write error: %s \n", err)
+	}
+
+	return oack_data
+}
+
+// resend writes an already marshalled packet to the peer of a transfer.
+func (s *TftpServer) resend(connection *transferConn, packet []byte) {
+	_, err := connection.Write(packet)
+
+	if err != nil {
+		fmt.Printf("Packet write error: %s \n", err)
+	}
 }
 
-func (s *TftpServer) sendError(connection *net.UDPConn, addr *net.UDPAddr, errCode ErrorCode, msg string) {
+// abortTransfer notifies the peer of a transfer that it is being terminated.
+func (s *TftpServer) abortTransfer(connection *transferConn, errCode ErrorCode, msg string) {
 	errPacket := PacketError{
 		Op:    OpError,
 		Error: errCode,
@@ -298,13 +821,131 @@ func (s *TftpServer) sendError(connection *net.UDPConn, addr *net.UDPAddr, errCo
 		fmt.Printf("Error marshalling error: %s \n", err)
 	}
 
-	_, err = connection.WriteToUDP(err_data, addr)
+	s.resend(connection, err_data)
+}
+
+// checkUploadSize checks the size of a file declared by the client with the
+// tsize option, or -1, against the limits of the storage.
+func (s *TftpServer) checkUploadSize(filename string, size int) error {
+	if size < 0 {
+		return nil
+	}
+
+	if limited, ok := s.fileStorage.(sizeLimitedStorage); ok {
+		return limited.checkUploadSize(filename, int64(size))
+	}
+
+	return nil
+}
+
+// completeUpload writes what is left of a netascii upload and commits it.
+func (s *TftpServer) completeUpload(upload Upload, decoder *netasciiWriter) error {
+	if decoder != nil {
+		if err := decoder.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return upload.Commit()
+}
+
+// abortStorageError terminates a transfer the storage failed, telling the
+// peer with the matching TFTP error code.
+func (s *TftpServer) abortStorageError(connection *transferConn, err error, filename string) {
+	code := storageErrorCode(err)
+
+	fmt.Printf("Storage error for file %s on port %s: %s \n", filename, connection.port(), err)
+	s.abortTransfer(connection, code, storageErrorMessage(code, filename))
+}
+
+// sendStorageError rejects a request the storage failed with the matching TFTP error code.
+func (s *TftpServer) sendStorageError(connection net.PacketConn, addr net.Addr, err error, filename string) {
+	code := storageErrorCode(err)
+	s.sendError(connection, addr, code, storageErrorMessage(code, filename))
+}
+
+func (s *TftpServer) sendError(connection net.PacketConn, addr net.Addr, errCode ErrorCode, msg string) {
+	errPacket := PacketError{
+		Op:    OpError,
+		Error: errCode,
+		Msg:   msg,
+	}
+
+	err_data, err := errPacket.MarshalBinary()
+
+	if err != nil {
+		fmt.Printf("Error marshalling error: %s \n", err)
+	}
+
+	_, err = connection.WriteTo(err_data, addr)
 
 	if err != nil {
 		fmt.Printf("Error packet write error: %s \n", err)
 	}
 }
 
+// listenNetwork returns the network to listen on, "udp" unless Network is set.
+func (s *TftpServer) listenNetwork() string {
+	if s.Network != "" {
+		return s.Network
+	}
+
+	return "udp"
+}
+
+// listenAddress returns the address to listen on, Addr or else Port on all interfaces.
+func (s *TftpServer) listenAddress() string {
+	if s.Addr != "" {
+		return s.Addr
+	}
+
+	return net.JoinHostPort("", strconv.Itoa(s.Port))
+}
+
+// listenPacket opens a socket with ListenPacket, or net.ListenPacket when it is unset.
+func (s *TftpServer) listenPacket(network string, address string) (net.PacketConn, error) {
+	if s.ListenPacket != nil {
+		return s.ListenPacket(network, address)
+	}
+
+	return net.ListenPacket(network, address)
+}
+
+// listenTransfer opens the socket of a new transfer with peer on the given
+// port, on the same interface as the listener. A listener bound to the
+// wildcard address may serve IPv4 and IPv6 peers alike, so its transfer
+// sockets are opened on the address family of the peer.
+func (s *TftpServer) listenTransfer(listener net.PacketConn, peer net.Addr, port int) (net.PacketConn, error) {
+	localAddr := listener.LocalAddr()
+	network := localAddr.Network()
+
+	host, _, err := net.SplitHostPort(localAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	localUDPAddr, isLocalUDP := localAddr.(*net.UDPAddr)
+	peerUDPAddr, isPeerUDP := peer.(*net.UDPAddr)
+
+	if isLocalUDP && isPeerUDP && (localUDPAddr.IP == nil || localUDPAddr.IP.IsUnspecified()) {
+		if peerUDPAddr.IP.To4() != nil {
+			network, host = "udp4", net.IPv4zero.String()
+		} else {
+			network, host = "udp6", net.IPv6unspecified.String()
+		}
+	}
+
+	return s.listenPacket(network, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// logPeerError reports an ERROR packet sent by the client, which terminates the transfer.
+func (s *TftpServer) logPeerError(packet []byte, port string) {
+	var errorPacket PacketError
+	errorPacket.UnmarshalBinary(packet)
+
+	fmt.Printf("Client aborted transfer on port %s: %s %s \n", port, errorPacket.Error, errorPacket.Msg)
+}
+
 func (s *TftpServer) logErrorIfExists(err error) {
 	if err != nil {
 		fmt.Printf("Error occured: %s \n", err)
@@ -316,7 +957,3 @@ func (s *TftpServer) handleCriticalError(err error) {
 		log.Fatal(err)
 	}
 }
-
-func (s *TftpServer) selectRandomPort() int {
-	return rand.Intn(max_data_port-min_data_port) + min_data_port
-}
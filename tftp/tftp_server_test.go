@@ -1,11 +1,19 @@
 package tftp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
+	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -18,109 +26,224 @@ const (
 
 func TestReadFileDoesNotExist(t *testing.T) {
 	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
-	mock_file_storage.EXPECT().GetFileMetadata("non-existing-file").Return(FileMetadata{}, false)
+	mock_file_storage.EXPECT().Open("non-existing-file").Return(nil, 0, fs.ErrNotExist)
 
-	go func() {
-		err := tftp_server.Start()
-		assert.NoError(t, err)
-	}()
-
-	time.Sleep(1 * time.Second)
+	startServer(t, tftp_server)
 
-	conn := createClientServerConnection(t, client_port, server_port)
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
 	defer conn.Close()
 
-	sendReadRequest(t, conn, "non-existing-file", "octet")
+	sendReadRequest(t, conn, server_addr, "non-existing-file", "octet")
 	assertReceivedError(t, conn, ErrFileNotFound)
 }
 
 func TestReadInvalidMode(t *testing.T) {
 	tftp_server, _, server_port, client_port := getTestResources(t)
 
-	go func() {
-		err := tftp_server.Start()
-		assert.NoError(t, err)
-	}()
-
-	time.Sleep(1 * time.Second)
+	startServer(t, tftp_server)
 
-	conn := createClientServerConnection(t, client_port, server_port)
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
 	defer conn.Close()
 
-	sendReadRequest(t, conn, "any_file", "netascii")
+	sendReadRequest(t, conn, server_addr, "any_file", "mail")
 	assertReceivedError(t, conn, ErrIllegal)
 }
 
 func TestReadFileExistsAndUnderOneBlock(t *testing.T) {
 	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
-	file_metadata := FileMetadata{
-		Filename:   "existing-file",
-		IsComplete: true,
-	}
-
 	fmt.Printf("TestReadFileExistsAndUnderOneBlock server_port: %d, client_port: %d \n", server_port, client_port)
 
-	mock_file_storage.EXPECT().GetFileMetadata("existing-file").Return(file_metadata, true)
-	mock_file_storage.EXPECT().ReadFileBytes("existing-file", 0, 512).Return([]byte("Hello World"))
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
 
-	go func() {
-		err := tftp_server.Start()
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	assertReceivedData(t, conn, []byte("Hello World"))
+}
+
+func TestReadIgnoresUnknownOptions(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "x-unknown", Value: "1"}},
+	})
+	assertReceivedData(t, conn, []byte("Hello World"))
+}
+
+func TestMalformedRequestIsRejected(t *testing.T) {
+	tftp_server, _, server_port, client_port := getTestResources(t)
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	for _, op := range []Op{OpRead, OpWrite} {
+		packet, err := PacketRequest{Op: op, Filename: "any_file", Mode: "octet"}.MarshalBinary()
 		assert.NoError(t, err)
-	}()
 
-	time.Sleep(1 * time.Second)
+		// The last option has a name but no value.
+		_, err = conn.WriteToUDP(append(packet, "blksize\x00"...), server_addr)
+		assert.NoError(t, err)
 
-	conn := createClientServerConnection(t, client_port, server_port)
+		assertReceivedError(t, conn, ErrIllegal)
+	}
 
-	sendReadRequest(t, conn, "existing-file", "octet")
-	conn.Close()
-	// assertReceivedData(t, client_port, []byte("Hello World"))
+	assert.Empty(t, tftp_server.Transfers())
 }
 
-func getTestResources(t *testing.T) (TftpServer, *MockFileStorage, int, int) {
+func getTestResources(t *testing.T) (*TftpServer, *MockFileStorage, int, int) {
 	server_port := selectRandomPort()
 	client_port := selectRandomPort()
 	mock_file_storage := NewMockFileStorage(t)
 
-	tftp_server := TftpServer{
+	tftp_server := &TftpServer{
 		Port:        server_port,
 		fileStorage: mock_file_storage,
-		uploads:     map[string]string{},
-		downloads:   map[string]DownloadMetadata{},
 	}
 
+	// Stop the server and its transfers before the mock checks its expectations.
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+	})
+
 	return tftp_server, mock_file_storage, server_port, client_port
 }
 
-func createClientServerConnection(t *testing.T, client_port int, server_port int) *net.UDPConn {
+// listenServer opens the listening socket of a server, so requests can be sent
+// to it as soon as it is handed to Serve.
+func listenServer(t *testing.T, tftp_server *TftpServer) net.PacketConn {
+	connection, err := tftp_server.listenPacket(tftp_server.listenNetwork(), tftp_server.listenAddress())
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	return connection
+}
+
+// startServer serves requests on a listening socket opened before it returns,
+// rather than waiting for Start to open it.
+func startServer(t *testing.T, tftp_server *TftpServer) {
+	connection := listenServer(t, tftp_server)
+
+	go func() {
+		err := tftp_server.Serve(context.Background(), connection)
+		if err != ErrServerClosed {
+			assert.NoError(t, err)
+		}
+	}()
+}
+
+// createClientServerConnection opens an unconnected client socket, since replies
+// to a request arrive from the transfer port rather than the server port.
+func createClientServerConnection(t *testing.T, client_port int, server_port int) (*net.UDPConn, *net.UDPAddr) {
 	server_addr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(server_port))
 	assert.NoError(t, err, "Failed to resolve server address.")
 
 	client_addr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(client_port))
 	assert.NoError(t, err, "Failed to resolve client address.")
 
-	conn, err := net.DialUDP("udp4", client_addr, server_addr)
+	conn, err := net.ListenUDP("udp4", client_addr)
+
+	assert.NoError(t, err, "Failed to open client socket.")
+	return conn, server_addr
+}
+
+// expectFile makes the storage serve a file with the given contents every time it is opened.
+func expectFile(storage *MockFileStorage, filename string, contents []byte) {
+	storage.EXPECT().Open(filename).RunAndReturn(func(string) (io.ReadCloser, int64, error) {
+		return io.NopCloser(bytes.NewReader(contents)), int64(len(contents)), nil
+	})
+}
+
+// expectUpload makes the storage accept an upload of a file, the returned mock
+// expects the data written to it. The upload is always closed once the
+// transfer ends, so the expectations are checked after the server has stopped.
+func expectUpload(t *testing.T, tftp_server *TftpServer, storage *MockFileStorage, filename string) *MockUpload {
+	upload := &MockUpload{}
+	upload.Mock.Test(t)
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+		upload.AssertExpectations(t)
+	})
+
+	upload.EXPECT().Close().Return(nil).Once()
+	storage.EXPECT().Create(filename).Return(upload, nil).Once()
+	return upload
+}
+
+func sendPacket(t *testing.T, conn *net.UDPConn, addr *net.UDPAddr, packet interface{ MarshalBinary() ([]byte, error) }) {
+	data, err := packet.MarshalBinary()
+	assert.NoError(t, err)
+
+	_, err = conn.WriteToUDP(data, addr)
+	assert.NoError(t, err)
+}
+
+// repeatPacket sends a packet over and over at the given interval until the
+// returned function is called.
+func repeatPacket(t *testing.T, conn *net.UDPConn, addr *net.UDPAddr, packet interface{ MarshalBinary() ([]byte, error) }, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
 
-	assert.NoError(t, err, "Failed to connect to server.")
-	return conn
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sendPacket(t, conn, addr, packet)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
 }
 
-func sendReadRequest(t *testing.T, conn *net.UDPConn, filename string, mode string) {
-	read_packet := PacketRequest{
+func sendReadRequest(t *testing.T, conn *net.UDPConn, addr *net.UDPAddr, filename string, mode string) {
+	sendPacket(t, conn, addr, PacketRequest{
 		Op:       OpRead,
 		Filename: filename,
 		Mode:     mode,
-	}
+	})
+}
 
-	data, err := read_packet.MarshalBinary()
-	assert.NoError(t, err)
-	conn.Write(data)
+// receivePacket waits for the next packet sent to the client and returns it
+// along with the address of the sender.
+func receivePacket(t *testing.T, conn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	buffer := make([]byte, MaxPacketSize)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, addr, err := conn.ReadFromUDP(buffer)
+	assert.NoError(t, err, "Expected to receive a packet.")
+
+	return buffer[:n], addr
 }
 
 func assertReceivedError(t *testing.T, conn *net.UDPConn, expectedErrorCode ErrorCode) {
-	buffer := make([]byte, 512)
-
-	conn.ReadFromUDP(buffer)
+	buffer, _ := receivePacket(t, conn)
 
 	op, err := PeekOp(buffer)
 	assert.NoError(t, err)
@@ -132,29 +255,943 @@ func assertReceivedError(t *testing.T, conn *net.UDPConn, expectedErrorCode Erro
 	assert.Equal(t, expectedErrorCode, errorPacket.Error, "Expected error code does not match.")
 }
 
-func assertReceivedData(t *testing.T, client_port int, expectedContent []byte) {
-	client_addr, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(client_port))
-	assert.NoError(t, err)
+func assertReceivedData(t *testing.T, conn *net.UDPConn, expectedContent []byte) *net.UDPAddr {
+	buffer, addr := receivePacket(t, conn)
 
-	conn, err := net.ListenUDP("udp4", client_addr)
+	op, err := PeekOp(buffer)
 	assert.NoError(t, err)
+	assert.Equal(t, OpData, op, "Expected to receive data packet.")
+
+	var dataPacket PacketData
+	dataPacket.UnmarshalBinary(buffer)
+
+	assert.Equal(t, expectedContent, dataPacket.Data, "Expected data content does not match.")
+	return addr
+}
+
+func selectRandomPort() int {
+	return rand.Intn(max_server_port-min_server_port) + min_server_port
+}
+
+func TestReadNegotiatesBlockSize(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
 
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "BLKSIZE", Value: "1024"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "1024"}})
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+	assertReceivedData(t, conn, []byte("Hello World"))
+}
+
+func TestWriteNegotiatesBlockSize(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("12345678")).Return(8, nil).Once()
+	mock_upload.EXPECT().Write([]byte("9")).Return(1, nil).Once()
+	mock_upload.EXPECT().Commit().Return(nil).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: "new-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("12345678")})
+	assertReceivedAck(t, conn, 1)
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 2, Data: []byte("9")})
+	assertReceivedAck(t, conn, 2)
+}
+
+func TestReadInvalidBlockSize(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
 	defer conn.Close()
 
-	buffer := make([]byte, 512)
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "4"}},
+	})
+	assertReceivedError(t, conn, ErrOptionNegotiation)
+}
 
-	conn.ReadFromUDP(buffer)
+func assertReceivedOAck(t *testing.T, conn *net.UDPConn, expectedOptions []Option) *net.UDPAddr {
+	buffer, addr := receivePacket(t, conn)
 
 	op, err := PeekOp(buffer)
 	assert.NoError(t, err)
-	assert.Equal(t, OpData, op, "Expected to receive data packet.")
+	assert.Equal(t, OpOAck, op, "Expected to receive OACK packet.")
 
-	var dataPacket PacketData
-	dataPacket.UnmarshalBinary(buffer)
+	var oackPacket PacketOAck
+	oackPacket.UnmarshalBinary(buffer)
 
-	assert.Equal(t, expectedContent, dataPacket.Data, "Expected data content does not match.")
+	assert.Equal(t, expectedOptions, oackPacket.Options, "Expected acknowledged options do not match.")
+	return addr
 }
 
-func selectRandomPort() int {
-	return rand.Intn(max_data_port-min_data_port) + min_data_port
+func assertReceivedAck(t *testing.T, conn *net.UDPConn, expectedBlockNum uint16) *net.UDPAddr {
+	buffer, addr := receivePacket(t, conn)
+
+	op, err := PeekOp(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, OpAck, op, "Expected to receive ACK packet.")
+
+	var ackPacket PacketAck
+	ackPacket.UnmarshalBinary(buffer)
+
+	assert.Equal(t, expectedBlockNum, ackPacket.BlockNum, "Expected block number does not match.")
+	return addr
+}
+
+func TestReadRetransmitsUnacknowledgedData(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.Timeout = 100 * time.Millisecond
+	tftp_server.MaxRetries = 2
+
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	assertReceivedData(t, conn, []byte("Hello World"))
+	assertReceivedData(t, conn, []byte("Hello World"))
+	assertReceivedData(t, conn, []byte("Hello World"))
+	assertReceivedError(t, conn, ErrNotDefined)
+}
+
+func TestReadRetransmitsDespiteStaleAcks(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.Timeout = 500 * time.Millisecond
+
+	contents := bytes.Repeat([]byte("x"), defaultBlockSize+1)
+	expectFile(mock_file_storage, "existing-file", contents)
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	data_addr := assertReceivedData(t, conn, contents[:defaultBlockSize])
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+
+	// DATA 2 is dropped, and the client keeps repeating ACK 1 more often than
+	// the server times out.
+	assertReceivedData(t, conn, contents[defaultBlockSize:])
+
+	stop := repeatPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1}, 200*time.Millisecond)
+	defer stop()
+
+	assertReceivedData(t, conn, contents[defaultBlockSize:])
+}
+
+func TestWriteRetransmitsDespiteOutOfOrderData(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.Timeout = 500 * time.Millisecond
+
+	expectUpload(t, tftp_server, mock_file_storage, "new-file")
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new-file", Mode: "octet"})
+	data_addr := assertReceivedAck(t, conn, 0)
+
+	// Blocks from the future are rejected and must not hold back the ACK
+	// resent when the server times out.
+	stop := repeatPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 5, Data: []byte("Hello World")}, 200*time.Millisecond)
+	defer stop()
+
+	assertReceivedAck(t, conn, 0)
+}
+
+func TestWriteRetransmitsUnansweredAck(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.Timeout = 100 * time.Millisecond
+	tftp_server.MaxRetries = 2
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("Hello World")).Return(11, nil).Once()
+	mock_upload.EXPECT().Commit().Return(nil).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new-file", Mode: "octet"})
+	assertReceivedAck(t, conn, 0)
+	data_addr := assertReceivedAck(t, conn, 0)
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assertReceivedAck(t, conn, 1)
+
+	// The final block is repeated as if the last ACK got lost.
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assertReceivedAck(t, conn, 1)
+}
+
+func TestReadReportsTransferSize(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "tsize", Value: "0"}, {Name: "timeout", Value: "2"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "tsize", Value: "11"}, {Name: "timeout", Value: "2"}})
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+	assertReceivedData(t, conn, []byte("Hello World"))
+}
+
+func TestWriteRejectsDeclaredSizeOverLimit(t *testing.T) {
+	tftp_server, _, server_port, client_port := getTestResources(t)
+	tftp_server.MaxUploadSize = 1024
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: "new-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "tsize", Value: "1025"}},
+	})
+	assertReceivedError(t, conn, ErrDiskFull)
+}
+
+func TestReadSendsWindowOfBlocks(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	expectFile(mock_file_storage, "existing-file", []byte("block 01block 02block 0304"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}, {Name: "windowsize", Value: "2"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}, {Name: "windowsize", Value: "2"}})
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+	assertReceivedData(t, conn, []byte("block 01"))
+	assertReceivedData(t, conn, []byte("block 02"))
+
+	// Block 2 got lost: the server rolls back to it and completes the window with block 3.
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+	assertReceivedData(t, conn, []byte("block 02"))
+	assertReceivedData(t, conn, []byte("block 03"))
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 3})
+	assertReceivedData(t, conn, []byte("04"))
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 4})
+}
+
+func TestReadRollsOverBlockNum(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	const blockSize = 8
+	const windowSize = 64
+	// One block past the first wire block number rollover.
+	fileSize := 65536*blockSize + 3
+
+	expectFile(mock_file_storage, "large-file", make([]byte, fileSize))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "large-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}, {Name: "windowsize", Value: "64"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}, {Name: "windowsize", Value: "64"}})
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+
+	received := 0
+	expectedBlockNum := uint16(1)
+
+	for {
+		buffer, _ := receivePacket(t, conn)
+
+		var dataPacket PacketData
+		assert.NoError(t, dataPacket.UnmarshalBinary(buffer))
+		if !assert.Equal(t, expectedBlockNum, dataPacket.BlockNum) {
+			return
+		}
+
+		received += len(dataPacket.Data)
+		expectedBlockNum++
+
+		if len(dataPacket.Data) < blockSize || int(dataPacket.BlockNum)%windowSize == 0 {
+			sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: dataPacket.BlockNum})
+		}
+
+		if len(dataPacket.Data) < blockSize {
+			break
+		}
+	}
+
+	assert.Equal(t, fileSize, received)
+	assert.Equal(t, uint16(2), expectedBlockNum)
+}
+
+func TestWriteRollsOverBlockNum(t *testing.T) {
+	for _, rolloverToOne := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rollover to one %t", rolloverToOne), func(t *testing.T) {
+			tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+			tftp_server.RolloverToOne = rolloverToOne
+
+			const blockSize = 8
+			// One block past the first wire block number rollover.
+			blocks := 65537
+			contents := make([]byte, (blocks-1)*blockSize+3)
+			for i := range contents {
+				contents[i] = byte(i % 251)
+			}
+
+			startServer(t, tftp_server)
+
+			conn, server_addr := createClientServerConnection(t, client_port, server_port)
+			defer conn.Close()
+
+			sendPacket(t, conn, server_addr, PacketRequest{
+				Op:       OpWrite,
+				Filename: "large-file",
+				Mode:     "octet",
+				Options:  []Option{{Name: "blksize", Value: "8"}},
+			})
+			data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+			for block := 1; block <= blocks; block++ {
+				blockNum := uint16(block)
+				if block > 65535 && rolloverToOne {
+					blockNum = uint16((block-1)%65535 + 1)
+				}
+
+				end := block * blockSize
+				if end > len(contents) {
+					end = len(contents)
+				}
+
+				sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: blockNum, Data: contents[(block-1)*blockSize : end]})
+				assertReceivedAck(t, conn, blockNum)
+
+				if t.Failed() {
+					return
+				}
+			}
+
+			waitForTransfers(t, tftp_server)
+			assert.Equal(t, contents, readMemoryStorage(t, memory_storage, "large-file", 0, len(contents)))
+		})
+	}
+}
+
+func TestReadNetascii(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	content := []byte("line 1\nline 2\r")
+
+	expectFile(mock_file_storage, "text-file", content)
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "text-file", "NETASCII")
+	assertReceivedData(t, conn, []byte("line 1\r\nline 2\r\x00"))
+}
+
+func TestWriteRejectsUnknownTransferID(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("Hello World")).Return(11, nil).Once()
+	mock_upload.EXPECT().Commit().Return(nil).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	stranger, _ := createClientServerConnection(t, selectRandomPort(), server_port)
+	defer stranger.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new-file", Mode: "octet"})
+	data_addr := assertReceivedAck(t, conn, 0)
+
+	sendPacket(t, stranger, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Injected")})
+	assertReceivedError(t, stranger, ErrUnknownTransferID)
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assertReceivedAck(t, conn, 1)
+}
+
+func TestWriteDuplicateDataIsAcknowledgedButWrittenOnce(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("block 01")).Return(8, nil).Once()
+	mock_upload.EXPECT().Write([]byte("02")).Return(2, nil).Once()
+	mock_upload.EXPECT().Commit().Return(nil).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: "new-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("block 01")})
+	assertReceivedAck(t, conn, 1)
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("block 01")})
+	assertReceivedAck(t, conn, 1)
+
+	// A block from the future is rejected without an ACK.
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 3, Data: []byte("block 03")})
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 2, Data: []byte("02")})
+	assertReceivedAck(t, conn, 2)
+}
+
+func TestReadIgnoresDuplicateAck(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	expectFile(mock_file_storage, "existing-file", []byte("block 0102"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpRead,
+		Filename: "existing-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+	assertReceivedData(t, conn, []byte("block 01"))
+
+	// The repeated ACK 0 must not cause block 1 to be sent again.
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 0})
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+	assertReceivedData(t, conn, []byte("02"))
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 2})
+}
+
+func TestShutdownWaitsForTransfers(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	connection := listenServer(t, tftp_server)
+	served := make(chan error, 1)
+	go func() {
+		served <- tftp_server.Serve(context.Background(), connection)
+	}()
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- tftp_server.Shutdown(ctx)
+	}()
+
+	assert.Equal(t, ErrServerClosed, <-served)
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned before the transfer finished.")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+	assert.NoError(t, <-shutdown)
+}
+
+func TestShutdownClosesTransfersAfterDeadline(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	assertReceivedData(t, conn, []byte("Hello World"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, tftp_server.Shutdown(ctx))
+	tftp_server.transfers.wait()
+}
+
+func TestServeStopsWhenContextIsCancelled(t *testing.T) {
+	tftp_server, _, server_port, client_port := getTestResources(t)
+
+	connection := listenServer(t, tftp_server)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- tftp_server.Serve(ctx, connection)
+	}()
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	// Once a request is answered the server is known to be serving.
+	sendReadRequest(t, conn, server_addr, "any_file", "mail")
+	assertReceivedError(t, conn, ErrIllegal)
+
+	cancel()
+
+	select {
+	case err := <-served:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the context was cancelled.")
+	}
+}
+
+func TestListenAndServeReturnsContextError(t *testing.T) {
+	tftp_server, _, _, _ := getTestResources(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, tftp_server.ListenAndServe(ctx))
+}
+
+func TestReadOverIPv6(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.Addr = net.JoinHostPort("::1", strconv.Itoa(server_port))
+
+	client_addr := &net.UDPAddr{IP: net.IPv6loopback, Port: client_port}
+	conn, err := net.ListenUDP("udp6", client_addr)
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err)
+	}
+	defer conn.Close()
+
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	server_addr := &net.UDPAddr{IP: net.IPv6loopback, Port: server_port}
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+
+	assert.True(t, data_addr.IP.Equal(net.IPv6loopback), "Expected the transfer to use the IPv6 loopback address.")
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+}
+
+func TestTransferSocketUsesFamilyOfPeer(t *testing.T) {
+	tftp_server := NewServer(0)
+
+	listener, err := net.ListenPacket("udp", ":0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	ipv4_socket, err := tftp_server.listenTransfer(listener, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, 0)
+	assert.NoError(t, err)
+	defer ipv4_socket.Close()
+	assert.NotNil(t, ipv4_socket.LocalAddr().(*net.UDPAddr).IP.To4(), "Expected an IPv4 transfer socket for an IPv4 peer.")
+
+	ipv6_socket, err := tftp_server.listenTransfer(listener, &net.UDPAddr{IP: net.IPv6loopback, Port: 1}, 0)
+	if err != nil {
+		t.Skipf("IPv6 is not available: %s", err)
+	}
+	defer ipv6_socket.Close()
+	assert.Nil(t, ipv6_socket.LocalAddr().(*net.UDPAddr).IP.To4(), "Expected an IPv6 transfer socket for an IPv6 peer.")
+}
+
+func TestTransfersUseSeparateOSAssignedPorts(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	first_addr := assertReceivedData(t, conn, []byte("Hello World"))
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	second_addr := assertReceivedData(t, conn, []byte("Hello World"))
+
+	assert.NotEqual(t, first_addr.Port, second_addr.Port, "Expected concurrent transfers to use different ports.")
+
+	sendPacket(t, conn, first_addr, PacketAck{Op: OpAck, BlockNum: 1})
+	sendPacket(t, conn, second_addr, PacketAck{Op: OpAck, BlockNum: 1})
+}
+
+func TestTransfersUsePortRange(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	tftp_server.TransferPorts = PortRange{Min: server_port + 1, Max: server_port + 1}
+	expectFile(mock_file_storage, "existing-file", []byte("Hello World"))
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+	assert.Equal(t, server_port+1, data_addr.Port)
+
+	// The only port of the range is taken by the first transfer.
+	sendReadRequest(t, conn, server_addr, "existing-file", "octet")
+	assertReceivedError(t, conn, ErrNotDefined)
+
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+}
+
+func TestTransfersCanBeListedAndCancelled(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("block 01")).Return(8, nil).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: "new-file",
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("block 01")})
+	assertReceivedAck(t, conn, 1)
+
+	transfers := tftp_server.Transfers()
+	assert.Len(t, transfers, 1)
+
+	transfer := transfers[0]
+	assert.Equal(t, OpWrite, transfer.Op)
+	assert.Equal(t, "new-file", transfer.Filename)
+	assert.Equal(t, conn.LocalAddr().String(), transfer.Peer.String())
+	assert.Equal(t, data_addr.Port, transfer.LocalAddr().(*net.UDPAddr).Port)
+	assert.Equal(t, uint64(1), transfer.Blocks())
+	assert.Equal(t, int64(8), transfer.Bytes())
+
+	found, ok := tftp_server.Transfer(transfer.ID)
+	assert.True(t, ok)
+	assert.Same(t, transfer, found)
+
+	assert.True(t, tftp_server.CancelTransfer(transfer.ID))
+	assertReceivedError(t, conn, ErrNotDefined)
+
+	tftp_server.transfers.wait()
+	assert.Empty(t, tftp_server.Transfers())
+	assert.False(t, tftp_server.CancelTransfer(transfer.ID))
+}
+
+func TestReadReportsStorageErrors(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	mock_file_storage.EXPECT().Open("secret-file").Return(nil, 0, &fs.PathError{Op: "open", Path: "secret-file", Err: fs.ErrPermission})
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "secret-file", "octet")
+	assertReceivedError(t, conn, ErrAccessViolation)
+}
+
+func TestWriteReportsStorageErrors(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+	mock_file_storage.EXPECT().Create("existing-file").Return(nil, fs.ErrExist)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("Hello World")).Return(0, syscall.ENOSPC).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "existing-file", Mode: "octet"})
+	assertReceivedError(t, conn, ErrExists)
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new-file", Mode: "octet"})
+	data_addr := assertReceivedAck(t, conn, 0)
+
+	// The upload is closed, and so aborted, without being committed.
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assertReceivedError(t, conn, ErrDiskFull)
+}
+
+func TestWriteReportsFailedCommit(t *testing.T) {
+	tftp_server, mock_file_storage, server_port, client_port := getTestResources(t)
+
+	mock_upload := expectUpload(t, tftp_server, mock_file_storage, "new-file")
+	mock_upload.EXPECT().Write([]byte("Hello World")).Return(11, nil).Once()
+	mock_upload.EXPECT().Commit().Return(fs.ErrPermission).Once()
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new-file", Mode: "octet"})
+	data_addr := assertReceivedAck(t, conn, 0)
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("Hello World")})
+	assertReceivedError(t, conn, ErrAccessViolation)
+}
+
+func TestServeFilesFromDirectory(t *testing.T) {
+	storage, err := NewDirFileStorage(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(storage.Root(), "boot.img"), []byte("Hello World"), 0644))
+
+	server_port := selectRandomPort()
+	client_port := selectRandomPort()
+	tftp_server := NewServerWithStorage(server_port, storage)
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+	})
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "boot.img", "octet")
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+
+	sendReadRequest(t, conn, server_addr, "../boot.img", "octet")
+	assertReceivedError(t, conn, ErrAccessViolation)
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "new.img", Mode: "octet"})
+	data_addr = assertReceivedAck(t, conn, 0)
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("uploaded")})
+	assertReceivedAck(t, conn, 1)
+
+	contents, err := os.ReadFile(filepath.Join(storage.Root(), "new.img"))
+	assert.NoError(t, err)
+	assert.Equal(t, "uploaded", string(contents))
+}
+
+func TestServeReadOnlyFS(t *testing.T) {
+	server_port := selectRandomPort()
+	client_port := selectRandomPort()
+	tftp_server := NewServerWithStorage(server_port, NewFSFileStorage(fstest.MapFS{
+		"boot.img": {Data: []byte("Hello World")},
+	}))
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+	})
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	sendReadRequest(t, conn, server_addr, "boot.img", "octet")
+	data_addr := assertReceivedData(t, conn, []byte("Hello World"))
+	sendPacket(t, conn, data_addr, PacketAck{Op: OpAck, BlockNum: 1})
+
+	sendPacket(t, conn, server_addr, PacketRequest{Op: OpWrite, Filename: "boot.img", Mode: "octet"})
+	assertReceivedError(t, conn, ErrAccessViolation)
+}
+
+// getMemoryTestResources is getTestResources with a server keeping its files
+// in a MemoryFileStorage rather than a mock.
+func getMemoryTestResources(t *testing.T) (*TftpServer, *MemoryFileStorage, int, int) {
+	server_port := selectRandomPort()
+	client_port := selectRandomPort()
+	memory_storage := CreateEmptyMemoryStorage()
+
+	tftp_server := NewServerWithStorage(server_port, NewBlockStorageAdapter(memory_storage))
+	t.Cleanup(func() {
+		tftp_server.Close()
+		tftp_server.transfers.wait()
+	})
+
+	return tftp_server, memory_storage, server_port, client_port
+}
+
+// waitForTransfers waits until the server has no transfer left. Unlike
+// transfers.wait it goes through the lock of the registry, so it can't race
+// with the start of the transfers it waits for.
+func waitForTransfers(t *testing.T, tftp_server *TftpServer) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for len(tftp_server.Transfers()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the transfers to finish.")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startPartialUpload uploads the first full block of a file and leaves the transfer open.
+func startPartialUpload(t *testing.T, conn *net.UDPConn, server_addr *net.UDPAddr, filename string) *net.UDPAddr {
+	sendPacket(t, conn, server_addr, PacketRequest{
+		Op:       OpWrite,
+		Filename: filename,
+		Mode:     "octet",
+		Options:  []Option{{Name: "blksize", Value: "8"}},
+	})
+	data_addr := assertReceivedOAck(t, conn, []Option{{Name: "blksize", Value: "8"}})
+
+	sendPacket(t, conn, data_addr, PacketData{Op: OpData, BlockNum: 1, Data: []byte("new firm")})
+	assertReceivedAck(t, conn, 1)
+	return data_addr
+}
+
+func TestWriteAbortedByClientKeepsOldFile(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	uploadToMemoryStorage(memory_storage, "firmware.bin", "old firmware")
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	data_addr := startPartialUpload(t, conn, server_addr, "firmware.bin")
+	assert.Equal(t, []byte("old firmware"), readMemoryStorage(t, memory_storage, "firmware.bin", 0, 512))
+
+	sendPacket(t, conn, data_addr, PacketError{Op: OpError, Error: ErrNotDefined, Msg: "Client gave up."})
+	waitForTransfers(t, tftp_server)
+
+	assert.Equal(t, []byte("old firmware"), readMemoryStorage(t, memory_storage, "firmware.bin", 0, 512))
+}
+
+func TestWriteTimeoutDiscardsUpload(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	tftp_server.Timeout = 100 * time.Millisecond
+	tftp_server.MaxRetries = 1
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	startPartialUpload(t, conn, server_addr, "firmware.bin")
+	waitForTransfers(t, tftp_server)
+
+	_, exists := memory_storage.GetFileMetadata("firmware.bin")
+	assert.False(t, exists)
+}
+
+func TestShutdownDiscardsUpload(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+	uploadToMemoryStorage(memory_storage, "firmware.bin", "old firmware")
+
+	startServer(t, tftp_server)
+
+	conn, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer conn.Close()
+
+	startPartialUpload(t, conn, server_addr, "firmware.bin")
+
+	tftp_server.Close()
+	waitForTransfers(t, tftp_server)
+
+	assert.Equal(t, []byte("old firmware"), readMemoryStorage(t, memory_storage, "firmware.bin", 0, 512))
+}
+
+func TestConcurrentUploadsOfSameFileDoNotMix(t *testing.T) {
+	tftp_server, memory_storage, server_port, client_port := getMemoryTestResources(t)
+
+	startServer(t, tftp_server)
+
+	first, server_addr := createClientServerConnection(t, client_port, server_port)
+	defer first.Close()
+	second, _ := createClientServerConnection(t, selectRandomPort(), server_port)
+	defer second.Close()
+
+	first_addr := startPartialUpload(t, first, server_addr, "firmware.bin")
+	second_addr := startPartialUpload(t, second, server_addr, "firmware.bin")
+
+	sendPacket(t, second, second_addr, PacketData{Op: OpData, BlockNum: 2, Data: []byte(" 2")})
+	assertReceivedAck(t, second, 2)
+	assert.Equal(t, []byte("new firm 2"), readMemoryStorage(t, memory_storage, "firmware.bin", 0, 512))
+
+	sendPacket(t, first, first_addr, PacketData{Op: OpData, BlockNum: 2, Data: []byte(" 1")})
+	assertReceivedAck(t, first, 2)
+	assert.Equal(t, []byte("new firm 1"), readMemoryStorage(t, memory_storage, "firmware.bin", 0, 512))
 }
@@ -0,0 +1,142 @@
+package tftp
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transfer is a read or write request being served. It owns the socket the
+// transfer runs on and counts its progress, which may be read while the
+// transfer is running.
+type Transfer struct {
+	// ID identifies the transfer among those of its server.
+	ID uint64
+	// Op is OpRead for a download and OpWrite for an upload.
+	Op       Op
+	Filename string
+	Peer     net.Addr
+	Started  time.Time
+
+	conn *transferConn
+	// file is the file sent by a download, upload the one received by an upload.
+	file         io.ReadCloser
+	upload       Upload
+	options      transferOptions
+	ackedOptions []Option
+
+	blocks atomic.Uint64
+	bytes  atomic.Int64
+}
+
+// LocalAddr returns the address of the socket the transfer runs on.
+func (t *Transfer) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+// Blocks returns the number of blocks acknowledged so far.
+func (t *Transfer) Blocks() uint64 {
+	return t.blocks.Load()
+}
+
+// Bytes returns the number of bytes of the file acknowledged so far.
+func (t *Transfer) Bytes() int64 {
+	return t.bytes.Load()
+}
+
+// Cancel aborts the transfer, telling the peer with an ERROR packet.
+func (t *Transfer) Cancel() {
+	errPacket := PacketError{
+		Op:    OpError,
+		Error: ErrNotDefined,
+		Msg:   "Transfer cancelled.",
+	}
+
+	if err_data, err := errPacket.MarshalBinary(); err == nil {
+		t.conn.Write(err_data)
+	}
+
+	t.conn.Close()
+}
+
+// progress records a block of n bytes acknowledged by the peer.
+func (t *Transfer) progress(n int) {
+	t.blocks.Add(1)
+	t.bytes.Add(int64(n))
+}
+
+// transferRegistry keeps track of the transfers in progress. It is safe for
+// concurrent use.
+type transferRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	active map[uint64]*Transfer
+	done   sync.WaitGroup
+}
+
+// add registers a new transfer and gives it an ID.
+func (r *transferRegistry) add(transfer *Transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.active == nil {
+		r.active = map[uint64]*Transfer{}
+	}
+
+	r.nextID++
+	transfer.ID = r.nextID
+	r.active[transfer.ID] = transfer
+	r.done.Add(1)
+}
+
+// remove forgets a finished transfer.
+func (r *transferRegistry) remove(transfer *Transfer) {
+	r.mu.Lock()
+	delete(r.active, transfer.ID)
+	r.mu.Unlock()
+
+	r.done.Done()
+}
+
+func (r *transferRegistry) get(id uint64) (*Transfer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transfer, ok := r.active[id]
+	return transfer, ok
+}
+
+// list returns the transfers in progress ordered by ID.
+func (r *transferRegistry) list() []*Transfer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transfers := make([]*Transfer, 0, len(r.active))
+	for _, transfer := range r.active {
+		transfers = append(transfers, transfer)
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].ID < transfers[j].ID
+	})
+
+	return transfers
+}
+
+// closeAll closes the sockets of every transfer in progress without notifying the peers.
+func (r *transferRegistry) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, transfer := range r.active {
+		transfer.conn.Close()
+	}
+}
+
+// wait blocks until every registered transfer has been removed.
+func (r *transferRegistry) wait() {
+	r.done.Wait()
+}
@@ -0,0 +1,97 @@
+package tftp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// transferConn is the socket of a single transfer. It is bound to its own port,
+// the server's transfer ID (TID), and talks to the single peer that made the
+// request.
+type transferConn struct {
+	net.PacketConn
+	peer net.Addr
+
+	// release returns the port of the transfer to the server once the socket is closed.
+	release     func()
+	releaseOnce sync.Once
+}
+
+// Close closes the socket of the transfer and releases its port. It may be
+// called more than once.
+func (c *transferConn) Close() error {
+	err := c.PacketConn.Close()
+
+	if c.release != nil {
+		c.releaseOnce.Do(c.release)
+	}
+
+	return err
+}
+
+// port returns the local port of the transfer, its TID, for logging.
+func (c *transferConn) port() string {
+	if udpAddr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+		return strconv.Itoa(udpAddr.Port)
+	}
+
+	return c.LocalAddr().String()
+}
+
+// Write sends a packet to the peer of the transfer.
+func (c *transferConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.peer)
+}
+
+// readFromPeer reads the next packet sent by the peer. Packets arriving from
+// any other address are answered with an ErrUnknownTransferID error and
+// otherwise ignored, as RFC 1350 requires, so they can't disturb the transfer.
+func (c *transferConn) readFromPeer(buffer []byte) (int, error) {
+	for {
+		n, addr, err := c.ReadFrom(buffer)
+		if err != nil {
+			return n, err
+		}
+
+		if sameAddr(addr, c.peer) {
+			return n, nil
+		}
+
+		fmt.Printf("Ignoring packet from unknown transfer ID %s on %s \n", addr, c.LocalAddr())
+		c.rejectUnknownPeer(addr)
+	}
+}
+
+func (c *transferConn) rejectUnknownPeer(addr net.Addr) {
+	errPacket := PacketError{
+		Op:    OpError,
+		Error: ErrUnknownTransferID,
+		Msg:   "Unknown transfer ID",
+	}
+
+	err_data, err := errPacket.MarshalBinary()
+
+	if err != nil {
+		fmt.Printf("Error marshalling error: %s \n", err)
+	}
+
+	_, err = c.WriteTo(err_data, addr)
+
+	if err != nil {
+		fmt.Printf("Error packet write error: %s \n", err)
+	}
+}
+
+// sameAddr reports whether two addresses identify the same transfer endpoint.
+func sameAddr(a net.Addr, b net.Addr) bool {
+	udpA, okA := a.(*net.UDPAddr)
+	udpB, okB := b.(*net.UDPAddr)
+
+	if okA && okB {
+		return udpA.Port == udpB.Port && udpA.IP.Equal(udpB.IP) && udpA.Zone == udpB.Zone
+	}
+
+	return a.Network() == b.Network() && a.String() == b.String()
+}
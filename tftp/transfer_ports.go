@@ -0,0 +1,146 @@
+package tftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PortRange is an inclusive range of UDP ports. The zero value leaves the
+// choice of port to the operating system.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses a range written as "min-max", or a single port.
+func ParsePortRange(value string) (PortRange, error) {
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+
+	min, errMin := strconv.Atoi(strings.TrimSpace(low))
+	max, errMax := strconv.Atoi(strings.TrimSpace(high))
+
+	if errMin != nil || errMax != nil {
+		return PortRange{}, fmt.Errorf("invalid port range: %q", value)
+	}
+
+	portRange := PortRange{Min: min, Max: max}
+	if err := portRange.validate(); err != nil {
+		return PortRange{}, err
+	}
+
+	return portRange, nil
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r PortRange) isZero() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+func (r PortRange) validate() error {
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return fmt.Errorf("invalid port range: %s", r)
+	}
+
+	return nil
+}
+
+// ErrNoFreePort is returned when every port of TftpServer.TransferPorts is taken.
+var ErrNoFreePort = errors.New("tftp: no free transfer port")
+
+// portPool hands out the ports of a PortRange to transfers. Ports in use by
+// the server are skipped, and ports taken by other programs are found out
+// when binding fails, so the next one is tried instead.
+type portPool struct {
+	mu    sync.Mutex
+	inUse map[int]struct{}
+	// next is where the search for a free port starts, so ports are reused
+	// as late as possible.
+	next int
+}
+
+// listen opens a socket on a free port of portRange using open, and returns
+// it along with a function releasing the port once the socket is closed.
+func (p *portPool) listen(portRange PortRange, open func(port int) (net.PacketConn, error)) (net.PacketConn, func(), error) {
+	if portRange.isZero() {
+		conn, err := open(0)
+		return conn, func() {}, err
+	}
+
+	if err := portRange.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	size := portRange.Max - portRange.Min + 1
+	var lastErr error
+
+	for tried := 0; tried < size; tried++ {
+		port, ok := p.reserve(portRange)
+		if !ok {
+			break
+		}
+
+		conn, err := open(port)
+		if err == nil {
+			return conn, func() { p.release(port) }, nil
+		}
+
+		// The port is taken by another program, keep it reserved while the
+		// remaining ports are tried so it isn't picked again.
+		defer p.release(port)
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, nil, fmt.Errorf("%w in %s: %s", ErrNoFreePort, portRange, lastErr)
+	}
+
+	return nil, nil, fmt.Errorf("%w in %s", ErrNoFreePort, portRange)
+}
+
+// reserve marks the next port of portRange not used by the server as in use.
+func (p *portPool) reserve(portRange PortRange) (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.inUse == nil {
+		p.inUse = map[int]struct{}{}
+	}
+
+	size := portRange.Max - portRange.Min + 1
+
+	start := p.next - portRange.Min
+	if start < 0 || start >= size {
+		start = 0
+	}
+
+	for i := 0; i < size; i++ {
+		port := portRange.Min + (start+i)%size
+
+		if _, taken := p.inUse[port]; taken {
+			continue
+		}
+
+		p.inUse[port] = struct{}{}
+		p.next = port + 1
+		return port, true
+	}
+
+	return 0, false
+}
+
+func (p *portPool) release(port int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.inUse, port)
+}
@@ -0,0 +1,92 @@
+package tftp
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	portRange, err := ParsePortRange("50000-50010")
+	assert.NoError(t, err)
+	assert.Equal(t, PortRange{Min: 50000, Max: 50010}, portRange)
+
+	portRange, err = ParsePortRange("50000")
+	assert.NoError(t, err)
+	assert.Equal(t, PortRange{Min: 50000, Max: 50000}, portRange)
+
+	for _, value := range []string{"", "abc", "50010-50000", "0-10", "60000-70000", "1-2-3"} {
+		_, err := ParsePortRange(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// openFakePort returns a socket opener that fails for the given ports, as if
+// they were taken by another program, and records every port it is asked for.
+func openFakePort(opened *[]int, taken ...int) func(port int) (net.PacketConn, error) {
+	return func(port int) (net.PacketConn, error) {
+		*opened = append(*opened, port)
+
+		for _, takenPort := range taken {
+			if port == takenPort {
+				return nil, errors.New("address already in use")
+			}
+		}
+
+		return newMemoryNetwork().ListenPacket("memory", "server:0")
+	}
+}
+
+func TestPortPoolHandsOutFreePorts(t *testing.T) {
+	var pool portPool
+	var opened []int
+	portRange := PortRange{Min: 50000, Max: 50002}
+
+	_, releaseFirst, err := pool.listen(portRange, openFakePort(&opened))
+	assert.NoError(t, err)
+	_, _, err = pool.listen(portRange, openFakePort(&opened))
+	assert.NoError(t, err)
+	_, _, err = pool.listen(portRange, openFakePort(&opened))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int{50000, 50001, 50002}, opened)
+
+	_, _, err = pool.listen(portRange, openFakePort(&opened))
+	assert.ErrorIs(t, err, ErrNoFreePort)
+
+	releaseFirst()
+	opened = nil
+
+	_, _, err = pool.listen(portRange, openFakePort(&opened))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{50000}, opened)
+}
+
+func TestPortPoolSkipsPortsTakenByOthers(t *testing.T) {
+	var pool portPool
+	var opened []int
+	portRange := PortRange{Min: 50000, Max: 50002}
+
+	_, _, err := pool.listen(portRange, openFakePort(&opened, 50000, 50001))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{50000, 50001, 50002}, opened)
+
+	// The ports that failed to bind are free to be tried again later.
+	assert.Equal(t, map[int]struct{}{50002: {}}, pool.inUse)
+
+	opened = nil
+	_, _, err = pool.listen(portRange, openFakePort(&opened, 50000, 50001))
+	assert.ErrorIs(t, err, ErrNoFreePort)
+	assert.Equal(t, []int{50000, 50001}, opened)
+}
+
+func TestPortPoolWithoutRangeUsesPortZero(t *testing.T) {
+	var pool portPool
+	var opened []int
+
+	_, _, err := pool.listen(PortRange{}, openFakePort(&opened))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, opened)
+}
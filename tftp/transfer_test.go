@@ -0,0 +1,31 @@
+package tftp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferRegistry(t *testing.T) {
+	var registry transferRegistry
+
+	first := &Transfer{Filename: "first"}
+	second := &Transfer{Filename: "second"}
+	registry.add(first)
+	registry.add(second)
+
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, []*Transfer{first, second}, registry.list())
+
+	found, ok := registry.get(second.ID)
+	assert.True(t, ok)
+	assert.Same(t, second, found)
+
+	registry.remove(first)
+	registry.remove(second)
+	registry.wait()
+
+	_, ok = registry.get(second.ID)
+	assert.False(t, ok)
+	assert.Empty(t, registry.list())
+}
@@ -7,8 +7,9 @@ import (
 	"io"
 )
 
-// larger than a typical mtu (1500), and largest DATA packet (516).
+// larger than a typical mtu (1500), and the default DATA packet (516).
 // may limit the length of filenames in RRQ/WRQs -- RFC1350 doesn't offer a bound for these.
+// DATA packets of transfers with a negotiated blksize are sized by the block size instead.
 const MaxPacketSize = 2048
 
 //go:generate stringer -type=Op
@@ -20,6 +21,7 @@ const (
 	OpData  Op = 3
 	OpAck   Op = 4
 	OpError Op = 5
+	OpOAck  Op = 6
 )
 
 // PeekOp determines the operation type of a TFTP packet
@@ -33,40 +35,48 @@ func PeekOp(b []byte) (Op, error) {
 type ErrorCode uint16
 
 const (
-	ErrNotDefined      ErrorCode = 1
-	ErrFileNotFound    ErrorCode = 2
-	ErrAccessViolation ErrorCode = 3
-	ErrDiskFull        ErrorCode = 4
-	ErrIllegal         ErrorCode = 5
-	ErrExists          ErrorCode = 6
-	ErrUnknownUser     ErrorCode = 7
+	ErrNotDefined        ErrorCode = 0
+	ErrFileNotFound      ErrorCode = 1
+	ErrAccessViolation   ErrorCode = 2
+	ErrDiskFull          ErrorCode = 3
+	ErrIllegal           ErrorCode = 4
+	ErrUnknownTransferID ErrorCode = 5
+	ErrExists            ErrorCode = 6
+	ErrUnknownUser       ErrorCode = 7
+	ErrOptionNegotiation ErrorCode = 8
 )
 
 var errorStrings = map[ErrorCode]string{
-	ErrNotDefined:      "Not defined",
-	ErrFileNotFound:    "File not found",
-	ErrAccessViolation: "Access Violation",
-	ErrDiskFull:        "Disk full or allocation exceeded",
-	ErrIllegal:         "Illegal TFTP operation",
-	ErrExists:          "File already exists",
-	ErrUnknownUser:     "No such user",
+	ErrNotDefined:        "Not defined",
+	ErrFileNotFound:      "File not found",
+	ErrAccessViolation:   "Access Violation",
+	ErrDiskFull:          "Disk full or allocation exceeded",
+	ErrIllegal:           "Illegal TFTP operation",
+	ErrUnknownTransferID: "Unknown transfer ID",
+	ErrExists:            "File already exists",
+	ErrUnknownUser:       "No such user",
+	ErrOptionNegotiation: "Option negotiation failed",
 }
 
 func (e ErrorCode) Error() string {
-	if e == 0 {
-		return ""
-	}
 	if s, ok := errorStrings[e]; ok {
 		return fmt.Sprintf("TFTP Error(%d) - %s", e, s)
 	}
 	return fmt.Sprintf("TFTP Error(%d) - Unknown", e)
 }
 
+// Option is a single option/value pair carried by a request or an OACK (RFC 2347).
+type Option struct {
+	Name  string
+	Value string
+}
+
 // PacketRequest represents a request to read from or write to a file.
 type PacketRequest struct {
 	Op       Op
 	Filename string
 	Mode     string
+	Options  []Option
 }
 
 func (p PacketRequest) MarshalBinary() ([]byte, error) {
@@ -76,6 +86,7 @@ func (p PacketRequest) MarshalBinary() ([]byte, error) {
 	b = append(b, 0)
 	b = append(b, p.Mode...)
 	b = append(b, 0)
+	b = appendOptions(b, p.Options)
 	return b, nil
 }
 
@@ -84,6 +95,27 @@ func (p *PacketRequest) UnmarshalBinary(b []byte) error {
 	p.Op = Op(d.uint16())
 	p.Filename = d.string()
 	p.Mode = d.string()
+	p.Options = d.options()
+	return d.err
+}
+
+// PacketOAck acknowledges the options accepted by the server (RFC 2347)
+type PacketOAck struct {
+	Op      Op
+	Options []Option
+}
+
+func (p PacketOAck) MarshalBinary() ([]byte, error) {
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, uint16(p.Op))
+	b = appendOptions(b, p.Options)
+	return b, nil
+}
+
+func (p *PacketOAck) UnmarshalBinary(b []byte) error {
+	d := decoder{p: b}
+	p.Op = Op(d.uint16())
+	p.Options = d.options()
 	return d.err
 }
 
@@ -154,6 +186,16 @@ func (p *PacketError) UnmarshalBinary(b []byte) error {
 	return d.err
 }
 
+func appendOptions(b []byte, options []Option) []byte {
+	for _, option := range options {
+		b = append(b, option.Name...)
+		b = append(b, 0)
+		b = append(b, option.Value...)
+		b = append(b, 0)
+	}
+	return b
+}
+
 type decoder struct {
 	p   []byte
 	err error
@@ -185,6 +227,19 @@ func (d *decoder) string() string {
 	return string(s)
 }
 
+// options reads option/value pairs until the end of the packet.
+func (d *decoder) options() []Option {
+	var options []Option
+	for d.err == nil && len(d.p) > 0 {
+		name := d.string()
+		value := d.string()
+		if d.err == nil {
+			options = append(options, Option{Name: name, Value: value})
+		}
+	}
+	return options
+}
+
 func (d *decoder) data() []byte {
 	if d.err != nil {
 		return nil
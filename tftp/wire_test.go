@@ -16,11 +16,15 @@ func TestSerializationDeserialization(t *testing.T) {
 	}{
 		{
 			[]byte("\x00\x01foo\x00bar\x00"),
-			&PacketRequest{OpRead, "foo", "bar"},
+			&PacketRequest{OpRead, "foo", "bar", nil},
 		},
 		{
 			[]byte("\x00\x02foo\x00bar\x00"),
-			&PacketRequest{OpWrite, "foo", "bar"},
+			&PacketRequest{OpWrite, "foo", "bar", nil},
+		},
+		{
+			[]byte("\x00\x01foo\x00bar\x00blksize\x001428\x00tsize\x000\x00"),
+			&PacketRequest{OpRead, "foo", "bar", []Option{{"blksize", "1428"}, {"tsize", "0"}}},
 		},
 		{
 			[]byte("\x00\x03\x12\x34fnord"),
@@ -38,6 +42,10 @@ func TestSerializationDeserialization(t *testing.T) {
 			[]byte("\x00\x05\xab\xcdparachute failure\x00"),
 			&PacketError{OpError, 0xabcd, "parachute failure"},
 		},
+		{
+			[]byte("\x00\x06blksize\x001428\x00"),
+			&PacketOAck{OpOAck, []Option{{"blksize", "1428"}}},
+		},
 	}
 
 	for _, test := range tests {
@@ -56,3 +64,11 @@ func TestSerializationDeserialization(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestWithTruncatedOption(t *testing.T) {
+	var p PacketRequest
+	err := p.UnmarshalBinary([]byte("\x00\x01foo\x00octet\x00blksize\x00"))
+	if err == nil {
+		t.Errorf("Expected an error for an option without a value; got %#v", p)
+	}
+}